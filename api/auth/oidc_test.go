@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const testClientID = "siot-client"
+
+// mockProvider is an identity provider with discovery, keys and a token endpoint
+// that checks the PKCE verifier of the code
+type mockProvider struct {
+	server *httptest.Server
+	issuer string
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PrivateKey
+	kid         string
+	codes       map[string]mockGrant
+	jwksFetches int
+}
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+}
+
+func newMockProvider(t *testing.T, trailingSlash bool) *mockProvider {
+
+	m := &mockProvider{keys: map[string]*rsa.PrivateKey{}, codes: map[string]mockGrant{}}
+	m.rotate(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.issuer,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.jwksFetches++
+
+		keys := []map[string]string{}
+		for kid, key := range m.keys {
+			keys = append(keys, map[string]string{
+				"kid": kid,
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		m.mu.Lock()
+		grant, ok := m.codes[r.PostForm.Get("code")]
+		delete(m.codes, r.PostForm.Get("code"))
+		m.mu.Unlock()
+
+		// the code is used once and only with the verifier of its challenge
+		if !ok || r.PostForm.Get("client_id") != testClientID || PKCEChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"id_token": m.sign(t, m.claims(grant.nonce)),
+		})
+	})
+
+	m.server = httptest.NewServer(mux)
+	m.issuer = m.server.URL
+	if trailingSlash {
+		m.issuer = m.server.URL + "/"
+	}
+	return m
+}
+
+// provider is the client of the mock, configured like NewOIDCProvider does
+func (m *mockProvider) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Issuer:      strings.TrimSuffix(m.issuer, "/"),
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost:8080/api/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+		HTTPClient:  m.server.Client(),
+	}
+}
+
+// rotate publishes a new signing key and removes the previous ones
+func (m *mockProvider) rotate(t *testing.T, kid string) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.mu.Lock()
+	m.keys = map[string]*rsa.PrivateKey{kid: key}
+	m.kid = kid
+	m.mu.Unlock()
+}
+
+// authorize is the login of the user, it returns the code of the callback
+func (m *mockProvider) authorize(t *testing.T, authorizationURL string) (string, url.Values) {
+
+	u, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+
+	m.mu.Lock()
+	code := "code-" + query.Get("state")
+	m.codes[code] = mockGrant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	m.mu.Unlock()
+
+	return code, query
+}
+
+func (m *mockProvider) claims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            m.issuer,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "ada@example.com",
+		"email_verified": true,
+	}
+}
+
+// sign signs the claims with the current key of the provider
+func (m *mockProvider) sign(t *testing.T, claims jwt.MapClaims) string {
+
+	m.mu.Lock()
+	kid, key := m.kid, m.keys[m.kid]
+	m.mu.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (m *mockProvider) fetches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksFetches
+}
+
+func TestOIDCLogin(t *testing.T) {
+
+	for _, trailingSlash := range []bool{false, true} {
+
+		m := newMockProvider(t, trailingSlash)
+		defer m.server.Close()
+		p := m.provider()
+
+		authorizationURL, err := p.AuthCodeURL("state-1", "nonce-1", "verifier-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		code, query := m.authorize(t, authorizationURL)
+
+		// the state and the nonce are sent back, the verifier only as its challenge
+		if query.Get("state") != "state-1" || query.Get("nonce") != "nonce-1" || query.Get("client_id") != testClientID {
+			t.Fatalf("unexpected authorization url %v", authorizationURL)
+		}
+		if query.Get("code_challenge") != PKCEChallenge("verifier-1") || query.Get("code_challenge_method") != "S256" {
+			t.Fatalf("unexpected PKCE challenge in %v", authorizationURL)
+		}
+		if strings.Contains(authorizationURL, "verifier-1") {
+			t.Fatal("the authorization url contains the verifier")
+		}
+
+		idToken, err := p.Exchange(code, "verifier-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		claims, err := p.VerifyIDToken(idToken, "nonce-1")
+		if err != nil {
+			t.Fatalf("issuer %q: %v", m.issuer, err)
+		}
+		if claims["email"] != "ada@example.com" {
+			t.Fatalf("unexpected claims %v", claims)
+		}
+	}
+}
+
+func TestOIDCExchangeChecksTheVerifier(t *testing.T) {
+
+	m := newMockProvider(t, false)
+	defer m.server.Close()
+	p := m.provider()
+
+	authorizationURL, _ := p.AuthCodeURL("state-1", "nonce-1", "verifier-1")
+	code, _ := m.authorize(t, authorizationURL)
+
+	if _, err := p.Exchange(code, "another-verifier"); err == nil {
+		t.Fatal("the code was exchanged with another verifier")
+	}
+
+	// the code is used once
+	if _, err := p.Exchange(code, "verifier-1"); err == nil {
+		t.Fatal("the code was exchanged twice")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+
+	m := newMockProvider(t, false)
+	defer m.server.Close()
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+		valid bool
+	}{
+		{"valid", func() string {
+			return m.sign(t, m.claims("nonce-1"))
+		}, true},
+		{"audience list", func() string {
+			claims := m.claims("nonce-1")
+			claims["aud"] = []interface{}{"another-client", testClientID}
+			return m.sign(t, claims)
+		}, true},
+		{"issuer with slash", func() string {
+			claims := m.claims("nonce-1")
+			claims["iss"] = m.issuer + "/"
+			return m.sign(t, claims)
+		}, false},
+		{"another issuer", func() string {
+			claims := m.claims("nonce-1")
+			claims["iss"] = "https://attacker.example"
+			return m.sign(t, claims)
+		}, false},
+		{"another audience", func() string {
+			claims := m.claims("nonce-1")
+			claims["aud"] = "another-client"
+			return m.sign(t, claims)
+		}, false},
+		{"another nonce", func() string {
+			return m.sign(t, m.claims("nonce-2"))
+		}, false},
+		{"missing nonce", func() string {
+			claims := m.claims("nonce-1")
+			delete(claims, "nonce")
+			return m.sign(t, claims)
+		}, false},
+		{"expired", func() string {
+			claims := m.claims("nonce-1")
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			return m.sign(t, claims)
+		}, false},
+		{"missing exp", func() string {
+			claims := m.claims("nonce-1")
+			delete(claims, "exp")
+			return m.sign(t, claims)
+		}, false},
+		{"key not published", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims("nonce-1"))
+			token.Header["kid"] = m.kid
+			signed, _ := token.SignedString(other)
+			return signed
+		}, false},
+		{"hmac", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, m.claims("nonce-1"))
+			signed, _ := token.SignedString([]byte("secret"))
+			return signed
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.provider().VerifyIDToken(tt.token(), "nonce-1")
+			if tt.valid && err != nil {
+				t.Fatalf("expected a valid token: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("expected an invalid token")
+			}
+		})
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+
+	m := newMockProvider(t, false)
+	defer m.server.Close()
+	p := m.provider()
+
+	before := m.sign(t, m.claims("nonce-1"))
+	if _, err := p.VerifyIDToken(before, "nonce-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.VerifyIDToken(before, "nonce-1"); err != nil {
+		t.Fatal(err)
+	}
+	if m.fetches() != 1 {
+		t.Fatalf("expected the keys to be cached, fetched %v times", m.fetches())
+	}
+
+	// the new kid is unknown, the keys are fetched again
+	m.rotate(t, "key-2")
+	after := m.sign(t, m.claims("nonce-1"))
+	if _, err := p.VerifyIDToken(after, "nonce-1"); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if m.fetches() != 2 {
+		t.Fatalf("expected the keys to be fetched again, fetched %v times", m.fetches())
+	}
+
+	// the removed key is not trusted anymore
+	if _, err := p.VerifyIDToken(before, "nonce-1"); err == nil {
+		t.Fatal("the token of the removed key is valid")
+	}
+}
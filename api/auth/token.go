@@ -2,6 +2,7 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,19 +14,61 @@ import (
 	"github.com/google/uuid"
 )
 
-func CreateToken(user_id uuid.UUID, is_admin bool, status string) (string, error) {
+// IsRevoked reports if a token was revoked by its jti, or by the user after it was
+// issued. The server replaces it with the revocation list of the database.
+var IsRevoked = func(jti string, user_id string, issued_at time.Time) bool {
+	return false
+}
+
+// AccessTokenTTL is the lifetime of the access tokens, ACCESS_TOKEN_TTL e.g. 15m
+func AccessTokenTTL() time.Duration {
+
+	ttl, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL"))
+	if err != nil || ttl <= 0 {
+		return 15 * time.Minute
+	}
+	return ttl
+}
+
+// CreateToken returns a short lived access token and its jti
+func CreateToken(user_id uuid.UUID, is_admin bool, status string) (string, string, error) {
+	jti := uuid.New().String()
+	now := time.Now()
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["user_id"] = user_id
 	claims["is_admin"] = is_admin
 	claims["status"] = status
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix() //Token expires after 24 hours
+	claims["jti"] = jti
+	claims["iat"] = float64(now.UnixNano()) / 1e9 // fractional seconds, revocations happen in the same second
+	claims["exp"] = now.Add(AccessTokenTTL()).Unix()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("API_SECRET")))
-
+	signed, err := token.SignedString([]byte(os.Getenv("API_SECRET")))
+	return signed, jti, err
 }
 
 func TokenValid(r *http.Request) error {
+	claims, err := ExtractClaims(r)
+	if err != nil {
+		return err
+	}
+	Pretty(claims)
+
+	// challenge tokens of the login are not access tokens
+	if _, ok := claims["purpose"]; ok {
+		return errors.New("invalid token")
+	}
+
+	iat, _ := claims["iat"].(float64)
+	issuedAt := time.Unix(0, int64(iat*1e9))
+	if IsRevoked(fmt.Sprintf("%v", claims["jti"]), fmt.Sprintf("%v", claims["user_id"]), issuedAt) {
+		return errors.New("token is revoked")
+	}
+	return nil
+}
+
+// ExtractClaims returns the claims of a valid token
+func ExtractClaims(r *http.Request) (jwt.MapClaims, error) {
 	tokenString := ExtractToken(r)
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -34,12 +77,13 @@ func TokenValid(r *http.Request) error {
 		return []byte(os.Getenv("API_SECRET")), nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		Pretty(claims)
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
 	}
-	return nil
+	return claims, nil
 }
 
 func ExtractToken(r *http.Request) string {
@@ -55,6 +99,19 @@ func ExtractToken(r *http.Request) string {
 	return ""
 }
 
+// ExtractApiKey returns the tenant api key sent in the X-Api-Key header or as token
+func ExtractApiKey(r *http.Request) string {
+	apiKey := r.Header.Get("X-Api-Key")
+	if apiKey != "" {
+		return apiKey
+	}
+	token := ExtractToken(r)
+	if strings.HasPrefix(token, "siot_") {
+		return token
+	}
+	return ""
+}
+
 func ExtractTokenID(r *http.Request) (string, error) {
 
 	tokenString := ExtractToken(r)
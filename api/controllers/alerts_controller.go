@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"net/http"
+
+	"siot/api/auth"
+	"siot/api/models"
+	"siot/api/responses"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (server *Server) ListAlerts(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	alert := models.Alert{}
+
+	alerts, err := alert.FindAllAlerts(server.DB, tenant_id, r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, alerts)
+}
+
+func (server *Server) ShowAlert(w http.ResponseWriter, r *http.Request) {
+
+	// get alert id
+	vars := mux.Vars(r)
+	alert_id := vars["alert_id"]
+
+	alert := models.Alert{}
+
+	a, err := alert.GetAlert(server.DB, alert_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, a)
+}
+
+// AcknowledgeAlert stops the escalation of the alert and records who acknowledged
+// it. With an api key the creator of the key is recorded.
+func (server *Server) AcknowledgeAlert(w http.ResponseWriter, r *http.Request) {
+
+	// get alert id
+	vars := mux.Vars(r)
+	alert_id := vars["alert_id"]
+
+	var uid_uuid uuid.UUID
+
+	if key := auth.ExtractApiKey(r); key != "" {
+		apiKey := models.ApiKey{}
+		k, err := apiKey.FindActiveApiKey(server.DB, key)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnauthorized, err)
+			return
+		}
+		uid_uuid = k.CreatedBy
+	} else {
+		user_id, err := auth.ExtractTokenID(r)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnauthorized, err)
+			return
+		}
+		uid_uuid, _ = uuid.Parse(user_id)
+	}
+
+	alert := models.Alert{}
+
+	a, err := alert.AcknowledgeAlert(server.DB, alert_id, uid_uuid)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, a)
+}
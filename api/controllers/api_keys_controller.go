@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"siot/api/auth"
+	"siot/api/models"
+	"siot/api/responses"
+	"siot/api/utils/formaterror"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (server *Server) CreateApiKey(w http.ResponseWriter, r *http.Request) {
+
+	// get user token
+	user_id, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	// convert tenant and user id to uuid
+	tid_uuid, _ := uuid.Parse(tenant_id)
+	uid_uuid, _ := uuid.Parse(user_id)
+
+	// get body info
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+	}
+
+	// get api key model
+	apiKey := models.ApiKey{}
+	err = json.Unmarshal(body, &apiKey)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// validate json fields
+	var validations formaterror.GeneralError = apiKey.ApiKeyValidations()
+	if len(validations.Errors) > 0 {
+		responses.JSON(w, http.StatusUnprocessableEntity, validations)
+		return
+	}
+
+	// insert api key
+	apiKeyCreated, err := apiKey.SaveApiKey(server.DB, tid_uuid, uid_uuid)
+
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusCreated, apiKeyCreated)
+}
+
+func (server *Server) ListApiKeys(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	apiKey := models.ApiKey{}
+
+	apiKeys, err := apiKey.FindAllApiKeys(server.DB, tenant_id, r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, apiKeys)
+}
+
+func (server *Server) RevokeApiKey(w http.ResponseWriter, r *http.Request) {
+
+	// get api key id
+	vars := mux.Vars(r)
+	api_key_id := vars["api_key_id"]
+
+	apiKey := models.ApiKey{}
+
+	err := apiKey.RevokeApiKey(server.DB, api_key_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -12,7 +14,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"siot/api/auth"
 	"siot/api/models"
+	"siot/api/mqtt"
 
 	_ "github.com/jinzhu/gorm/dialects/postgres" //postgres database driver
 	"github.com/rs/cors"
@@ -22,6 +26,14 @@ type Server struct {
 	DB     *gorm.DB
 	Router *mux.Router
 	MDB    *mongo.Client
+	MQTT   *mqtt.Broker
+	HTTP   *http.Server
+
+	Deliveries *models.DeliveryWorker
+	Scheduler  *models.RuleScheduler
+	Evaluator  *models.RuleEvaluator
+
+	OIDC *auth.OIDCProvider
 }
 
 func (server *Server) Initialize(DbUser, DbPassword, DbPort, DbHost, DbName, mongoHost string) {
@@ -50,12 +62,56 @@ func (server *Server) Initialize(DbUser, DbPassword, DbPort, DbHost, DbName, mon
 	// database migration
 	server.DB.AutoMigrate(&models.User{})
 
+	// revoked tokens are rejected by the authentication middleware
+	auth.IsRevoked = func(jti string, user_id string, issued_at time.Time) bool {
+		return models.IsTokenRevoked(server.DB, jti, user_id, issued_at)
+	}
+
+	// single sign-on, nil when OIDC_ISSUER is not set
+	server.OIDC = auth.NewOIDCProvider()
+
 	server.Router = mux.NewRouter()
 	server.initializeRoutes()
+	server.HTTP = &http.Server{Handler: cors.Default().Handler(server.Router)}
+
+	server.MQTT = mqtt.NewBroker(&mqttHandler{server: server})
+	server.MQTT.MaxPacketSize, _ = strconv.Atoi(os.Getenv("MQTT_MAX_PACKET_SIZE"))
+	server.Deliveries = models.NewDeliveryWorker(server.DB)
+	server.Scheduler = models.NewRuleScheduler(server.MDB, server.DB)
+	server.Evaluator = models.NewRuleEvaluator(server.MDB, server.DB)
 }
 
 func (server *Server) Run(addr string) {
 	fmt.Println("Listening to port 8080")
-	handler := cors.Default().Handler(server.Router)
-	log.Fatal(http.ListenAndServe(addr, handler))
+	server.HTTP.Addr = addr
+
+	// Shutdown closes the listener, Run returns while the requests are drained
+	if err := server.HTTP.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// Shutdown stops accepting requests and waits for the running ones, then stops
+// the background workers, the queued rule evaluations are drained
+func (server *Server) Shutdown() {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.HTTP.Shutdown(ctx); err != nil {
+		log.Printf("http server shutdown: %v", err)
+	}
+
+	fmt.Println("Draining the rule evaluations")
+	server.MQTT.Close()
+	server.Evaluator.Stop()
+	server.Scheduler.Stop()
+	server.Deliveries.Stop()
+}
+
+func (server *Server) RunMQTT(addr string) {
+	fmt.Println("Listening to MQTT on " + addr)
+	// Serve returns nil once Shutdown closed the broker, the workers are drained
+	if err := server.MQTT.ListenAndServe(addr); err != nil {
+		log.Fatal(err)
+	}
 }
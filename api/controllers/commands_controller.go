@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+	"siot/api/utils/formaterror"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (server *Server) CreateCommand(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant and device id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+	device_id := vars["device_id"]
+
+	// convert tenant and device id to uuid
+	tid_uuid, _ := uuid.Parse(tenant_id)
+	did_uuid, _ := uuid.Parse(device_id)
+
+	// get body info
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+	}
+
+	// get command model
+	command := models.Command{}
+	err = json.Unmarshal(body, &command)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// validate json fields
+	var validations formaterror.GeneralError = command.CommandValidations()
+	if len(validations.Errors) > 0 {
+		responses.JSON(w, http.StatusUnprocessableEntity, validations)
+		return
+	}
+
+	// insert command
+	commandCreated, err := command.SaveCommand(server.DB, tid_uuid, did_uuid)
+
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusCreated, commandCreated)
+}
+
+func (server *Server) ListCommands(w http.ResponseWriter, r *http.Request) {
+
+	// get device id
+	vars := mux.Vars(r)
+	device_id := vars["device_id"]
+
+	command := models.Command{}
+
+	commands, err := command.FindAllCommands(server.DB, device_id, r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, commands)
+}
+
+func (server *Server) ShowCommand(w http.ResponseWriter, r *http.Request) {
+
+	// get command id
+	vars := mux.Vars(r)
+	command_id := vars["command_id"]
+
+	command := models.Command{}
+
+	c, err := command.GetCommand(server.DB, command_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, c)
+}
+
+func (server *Server) DeleteCommand(w http.ResponseWriter, r *http.Request) {
+
+	// get command id
+	vars := mux.Vars(r)
+	command_id := vars["command_id"]
+
+	command := models.Command{}
+
+	err := command.DeleteCommand(server.DB, command_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (server *Server) PollCommands(w http.ResponseWriter, r *http.Request) {
+
+	// get device id
+	vars := mux.Vars(r)
+	device_id := vars["device_id"]
+
+	command := models.Command{}
+
+	commands, err := command.PollCommands(server.DB, device_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, commands)
+}
+
+func (server *Server) AckCommand(w http.ResponseWriter, r *http.Request) {
+
+	// get command id
+	vars := mux.Vars(r)
+	command_id := vars["command_id"]
+
+	// get body info, the response of the device is optional
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+	}
+
+	command := models.Command{}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &command)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+
+	c, err := command.AckCommand(server.DB, command_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, c)
+}
@@ -46,7 +46,7 @@ func (server *Server) SendData(w http.ResponseWriter, r *http.Request) {
 	did_uuid, _ := uuid.Parse(device_id)
 
 	// prepares data for insertion
-	err_validation := data.ValidateAndSendData(server.MDB, server.DB, did_uuid)
+	err_validation := data.ValidateAndSendData(server.MDB, server.DB, did_uuid, server.Evaluator)
 	if err_validation != nil {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err_validation)
 		return
@@ -77,3 +77,22 @@ func (server *Server) GetData(w http.ResponseWriter, r *http.Request) {
 
 	responses.JSON(w, http.StatusOK, result)
 }
+
+func (server *Server) AggregateData(w http.ResponseWriter, r *http.Request) {
+
+	// get device id
+	vars := mux.Vars(r)
+	device_id := vars["device_id"]
+
+	// convert device id to uuid
+	did_uuid, _ := uuid.Parse(device_id)
+
+	data := models.Data{}
+	result, err := data.AggregateData(server.MDB, server.DB, did_uuid, r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+
+	"github.com/gorilla/mux"
+)
+
+func (server *Server) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	delivery := models.Delivery{}
+
+	deliveries, err := delivery.FindAllDeliveries(server.DB, tenant_id, r.URL.Query().Get("status"), r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, deliveries)
+}
+
+func (server *Server) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	delivery := models.Delivery{}
+
+	deliveries, err := delivery.FindAllDeliveries(server.DB, tenant_id, models.DeliveryStatusDead, r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, deliveries)
+}
+
+func (server *Server) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+
+	// get delivery id
+	vars := mux.Vars(r)
+	delivery_id := vars["delivery_id"]
+
+	delivery := models.Delivery{}
+
+	d, err := delivery.ReplayDelivery(server.DB, delivery_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, d)
+}
@@ -123,6 +123,9 @@ func (server *Server) UpdateDevice(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
+	// the tags and profile select the group rules of the device
+	server.Evaluator.InvalidateRules()
+
 	responses.JSON(w, http.StatusOK, d)
 }
 
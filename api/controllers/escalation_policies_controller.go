@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+	"siot/api/utils/formaterror"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (server *Server) CreateEscalationPolicy(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	// convert tenant id to uuid
+	tid_uuid, _ := uuid.Parse(tenant_id)
+
+	// get body info
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// get policy model
+	policy := models.EscalationPolicy{}
+	err = json.Unmarshal(body, &policy)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// validate json fields
+	policy.PrepareUpdate()
+	var validations formaterror.GeneralError = policy.EscalationPolicyValidations(server.DB, tid_uuid)
+	if len(validations.Errors) > 0 {
+		responses.JSON(w, http.StatusUnprocessableEntity, validations)
+		return
+	}
+
+	// insert policy
+	policyCreated, err := policy.SaveEscalationPolicy(server.DB, tid_uuid)
+
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusCreated, policyCreated)
+}
+
+func (server *Server) ListEscalationPolicies(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	policy := models.EscalationPolicy{}
+
+	policies, err := policy.FindAllEscalationPolicies(server.DB, tenant_id, r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, policies)
+}
+
+func (server *Server) ShowEscalationPolicy(w http.ResponseWriter, r *http.Request) {
+
+	// get policy id
+	vars := mux.Vars(r)
+	policy_id := vars["policy_id"]
+
+	policy := models.EscalationPolicy{}
+
+	p, err := policy.GetEscalationPolicy(server.DB, policy_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, p)
+}
+
+func (server *Server) UpdateEscalationPolicy(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant and policy id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+	policy_id := vars["policy_id"]
+
+	// convert tenant id to uuid
+	tid_uuid, _ := uuid.Parse(tenant_id)
+
+	// get body info
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// get policy model
+	policy := models.EscalationPolicy{}
+	err = json.Unmarshal(body, &policy)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// prepares policy details for the database insertion
+	policy.PrepareUpdate()
+
+	// validate json fields
+	var validations formaterror.GeneralError = policy.EscalationPolicyValidations(server.DB, tid_uuid)
+	if len(validations.Errors) > 0 {
+		responses.JSON(w, http.StatusUnprocessableEntity, validations)
+		return
+	}
+
+	p, err := policy.UpdateEscalationPolicy(server.DB, policy_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, p)
+}
+
+func (server *Server) DeleteEscalationPolicy(w http.ResponseWriter, r *http.Request) {
+
+	// get policy id
+	vars := mux.Vars(r)
+	policy_id := vars["policy_id"]
+
+	policy := models.EscalationPolicy{}
+
+	err := policy.DeleteEscalationPolicy(server.DB, policy_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,14 +2,24 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"siot/api/auth"
 	"siot/api/models"
 	"siot/api/responses"
 	"siot/api/serializers"
 	"siot/api/utils/formaterror"
+
+	"github.com/google/uuid"
 )
 
 func (server *Server) Login(w http.ResponseWriter, r *http.Request) {
@@ -42,14 +52,39 @@ func (server *Server) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// get token
-	token, err := server.SignIn(user.Email, user.Password)
+	// the attempt is counted before the password is checked, emails and
+	// addresses with too many failed attempts wait
+	attempt, err := models.ReserveLogin(server.DB, user.Email, clientIP(r))
+	if loginLocked(w, err) {
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// get access and refresh tokens
+	tokens, mfaToken, err := server.SignIn(user.Email, user.Password)
 	if err != nil {
+		attempt.Failed(server.DB)
 		formattedError := formaterror.LoginError(err.Error())
 		responses.ERROR(w, http.StatusUnprocessableEntity, formattedError)
 		return
 	}
 
+	// the tokens are issued by /api/login/mfa after the second factor, the
+	// attempts of the email are kept until then
+	if mfaToken != "" {
+		attempt.PasswordVerified(server.DB)
+		responses.JSON(w, http.StatusOK, serializers.MFAChallengeSerializer{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int64(auth.MFATokenTTL.Seconds()),
+		})
+		return
+	}
+	attempt.Succeeded(server.DB)
+
 	// user information
 	userDetails, err := user.FindUserByEmail(server.DB, user.Email)
 	if err != nil {
@@ -58,13 +93,17 @@ func (server *Server) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var resp serializers.LoginSerializer
-	resp.Token = token
+	resp.Token = tokens.AccessToken
+	resp.RefreshToken = tokens.RefreshToken
+	resp.ExpiresIn = tokens.ExpiresIn
 	resp.User = userDetails.ShowUserSerializer()
 
 	responses.JSON(w, http.StatusOK, resp)
 }
 
-func (server *Server) SignIn(email, password string) (string, error) {
+// SignIn checks the password and returns the tokens of the user, or a challenge
+// token when the user has two-factor authentication
+func (server *Server) SignIn(email, password string) (*models.TokenPair, string, error) {
 
 	var err error
 
@@ -72,11 +111,171 @@ func (server *Server) SignIn(email, password string) (string, error) {
 
 	err = server.DB.Model(models.User{}).Where("email = ?", email).Take(&user).Error
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 	err = models.VerifyPassword(user.Password, password)
 	if err != nil {
-		return "", err
+		return nil, "", err
+	}
+	if user.Status == "inactive" {
+		return nil, "", errors.New("user is inactive")
+	}
+
+	if user.MFAEnabled {
+		mfaToken, err := auth.CreateMFAToken(user.ID)
+		return nil, mfaToken, err
+	}
+
+	tokens, err := models.IssueTokens(server.DB, &user)
+	return tokens, "", err
+}
+
+// LoginMFA is the second step of the login, it exchanges the challenge token and
+// a TOTP or recovery code for the tokens of the user
+func (server *Server) LoginMFA(w http.ResponseWriter, r *http.Request) {
+
+	// get json body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	var challenge struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	err = json.Unmarshal(body, &challenge)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	var validations formaterror.GeneralError
+	if challenge.MFAToken == "" {
+		validations.Errors = append(validations.Errors, "mfa_token is required")
+	}
+	if challenge.Code == "" {
+		validations.Errors = append(validations.Errors, "code is required")
+	}
+	if len(validations.Errors) > 0 {
+		responses.JSON(w, http.StatusUnprocessableEntity, validations)
+		return
+	}
+
+	user, tokens, err := models.CompleteMFALogin(server.DB, challenge.MFAToken, challenge.Code, clientIP(r))
+	if loginLocked(w, err) {
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	// user information
+	userDetails, err := user.FindUserByID(server.DB, user.ID.String())
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var resp serializers.LoginSerializer
+	resp.Token = tokens.AccessToken
+	resp.RefreshToken = tokens.RefreshToken
+	resp.ExpiresIn = tokens.ExpiresIn
+	resp.User = userDetails.ShowUserSerializer()
+
+	responses.JSON(w, http.StatusOK, resp)
+}
+
+// clientIP returns the address of the client, the X-Forwarded-For header is only
+// read when TRUST_PROXY_HEADERS is true
+func clientIP(r *http.Request) string {
+
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginLocked responds 429 with the Retry-After header when the error is a lockout
+func loginLocked(w http.ResponseWriter, err error) bool {
+
+	locked, ok := err.(*models.LoginLockedError)
+	if !ok {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+	responses.ERROR(w, http.StatusTooManyRequests, locked)
+	return true
+}
+
+// RefreshToken exchanges a refresh token for a new access token, the refresh
+// token is rotated and can not be used again
+func (server *Server) RefreshToken(w http.ResponseWriter, r *http.Request) {
+
+	// get json body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	var refresh struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err = json.Unmarshal(body, &refresh)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if refresh.RefreshToken == "" {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("refresh_token is required"))
+		return
+	}
+
+	tokens, err := models.RefreshTokens(server.DB, refresh.RefreshToken)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, tokens)
+}
+
+// Logout revokes the access token of the request and the refresh token sent in
+// the body
+func (server *Server) Logout(w http.ResponseWriter, r *http.Request) {
+
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("invalid token"))
+		return
+	}
+
+	var refresh struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	json.Unmarshal(body, &refresh)
+
+	uid_uuid, _ := uuid.Parse(fmt.Sprintf("%v", claims["user_id"]))
+	exp, _ := claims["exp"].(float64)
+	jti, _ := claims["jti"].(string)
+
+	err = models.Logout(server.DB, uid_uuid, jti, time.Unix(int64(exp), 0), refresh.RefreshToken)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
 	}
-	return auth.CreateToken(user.ID, user.IsAdmin, user.Status)
+	w.WriteHeader(http.StatusNoContent)
 }
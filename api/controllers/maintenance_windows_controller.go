@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+	"siot/api/utils/formaterror"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (server *Server) CreateMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	// convert tenant id to uuid
+	tid_uuid, _ := uuid.Parse(tenant_id)
+
+	// get body info
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// get window model
+	window := models.MaintenanceWindow{}
+	err = json.Unmarshal(body, &window)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// validate json fields
+	var validations formaterror.GeneralError = window.MaintenanceWindowValidations(server.DB, tid_uuid)
+	if len(validations.Errors) > 0 {
+		responses.JSON(w, http.StatusUnprocessableEntity, validations)
+		return
+	}
+
+	// insert window
+	windowCreated, err := window.SaveMaintenanceWindow(server.DB, tid_uuid)
+
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusCreated, windowCreated)
+}
+
+func (server *Server) ListMaintenanceWindows(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	window := models.MaintenanceWindow{}
+
+	windows, err := window.FindAllMaintenanceWindows(server.DB, tenant_id, r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, windows)
+}
+
+func (server *Server) DeleteMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+
+	// get window id
+	vars := mux.Vars(r)
+	window_id := vars["window_id"]
+
+	window := models.MaintenanceWindow{}
+
+	err := window.DeleteMaintenanceWindow(server.DB, window_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,12 @@
+package controllers
+
+import (
+	"net/http"
+
+	"siot/api/responses"
+)
+
+// RuleMetrics returns the queue and cache counters of the rule evaluator
+func (server *Server) RuleMetrics(w http.ResponseWriter, r *http.Request) {
+	responses.JSON(w, http.StatusOK, server.Evaluator.Metrics())
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"siot/api/auth"
+	"siot/api/models"
+	"siot/api/responses"
+	"siot/api/serializers"
+)
+
+// mfaCode reads the code of the second factor from the body
+func mfaCode(r *http.Request) (string, error) {
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var mfa struct {
+		Code string `json:"code"`
+	}
+	err = json.Unmarshal(body, &mfa)
+	if err != nil {
+		return "", err
+	}
+
+	if mfa.Code == "" {
+		return "", errors.New("code is required")
+	}
+	return mfa.Code, nil
+}
+
+// EnrollMFA starts the enrollment of the logged user, the secret and the
+// recovery codes are only returned here
+func (server *Server) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+
+	user_id, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	user := models.User{}
+	enrollment, err := user.EnrollMFA(server.DB, user_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusCreated, enrollment)
+}
+
+// VerifyMFA enables two-factor authentication with a code of the enrolled secret.
+// Every session of the user is closed and new tokens are returned.
+func (server *Server) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+
+	user_id, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	code, err := mfaCode(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	user := models.User{}
+	err = user.EnableMFA(server.DB, user_id, code)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	tokens, err := models.IssueTokens(server.DB, &user)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	u, err := user.FindUserByID(server.DB, user_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
+	var resp serializers.LoginSerializer
+	resp.Token = tokens.AccessToken
+	resp.RefreshToken = tokens.RefreshToken
+	resp.ExpiresIn = tokens.ExpiresIn
+	resp.User = u.ShowUserSerializer()
+
+	responses.JSON(w, http.StatusOK, resp)
+}
+
+// DisableMFA removes the second factor of the logged user, a TOTP or recovery
+// code is required
+func (server *Server) DisableMFA(w http.ResponseWriter, r *http.Request) {
+
+	user_id, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	code, err := mfaCode(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	user := models.User{}
+	err = user.DisableMFA(server.DB, user_id, code)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"siot/api/models"
+	"siot/api/mqtt"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+)
+
+// mqttHandler receives data published by devices on siot/{tenant_id}/{device_id}/data.
+// Devices connect with their device id as username and their secret key as password.
+type mqttHandler struct {
+	server *Server
+}
+
+func (h *mqttHandler) Authenticate(session *mqtt.Session) error {
+
+	// convert device id to uuid
+	did_uuid, err := uuid.Parse(session.Username)
+	if err != nil {
+		return errors.New("invalid device id")
+	}
+
+	device := models.Device{}
+	d, err := device.FindDevice(h.server.DB, did_uuid)
+	if err != nil {
+		return errors.New("device not found")
+	}
+
+	return device.ValidateDeviceCredentials(h.server.DB, did_uuid, d.TenantID, session.Password)
+}
+
+func (h *mqttHandler) Publish(session *mqtt.Session, topic string, payload []byte) error {
+
+	// get tenant and device id from the topic
+	levels := strings.Split(topic, "/")
+	if len(levels) != 4 || levels[0] != "siot" || levels[3] != "data" {
+		return errors.New("invalid topic")
+	}
+
+	// convert tenant and device id to uuid
+	tid_uuid, errTenantID := uuid.Parse(levels[1])
+	if errTenantID != nil {
+		return errors.New("invalid tenant id")
+	}
+	did_uuid, errDeviceID := uuid.Parse(levels[2])
+	if errDeviceID != nil {
+		return errors.New("invalid device id")
+	}
+
+	// a device can only publish its own data
+	if did_uuid.String() != session.Username {
+		return mqtt.ErrNotAuthorized
+	}
+
+	device := models.Device{}
+	if err := device.ValidateDeviceCredentials(h.server.DB, did_uuid, tid_uuid, session.Password); err != nil {
+		return mqtt.ErrNotAuthorized
+	}
+
+	// get data model
+	data := models.Data{}
+	err := json.Unmarshal(payload, &data)
+	if err != nil {
+		return err
+	}
+
+	// validate
+	validate := validator.New()
+	err = validate.Struct(data)
+	if err != nil {
+		return err
+	}
+
+	// prepares data for insertion
+	return data.ValidateAndSendData(h.server.MDB, h.server.DB, did_uuid, h.server.Evaluator)
+}
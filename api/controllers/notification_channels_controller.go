@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+	"siot/api/utils/formaterror"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (server *Server) CreateNotificationChannel(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	// convert tenant id to uuid
+	tid_uuid, _ := uuid.Parse(tenant_id)
+
+	// get body info
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// get channel model
+	channel := models.NotificationChannel{}
+	err = json.Unmarshal(body, &channel)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// validate json fields
+	channel.PrepareUpdate()
+	var validations formaterror.GeneralError = channel.NotificationChannelValidations()
+	if len(validations.Errors) > 0 {
+		responses.JSON(w, http.StatusUnprocessableEntity, validations)
+		return
+	}
+
+	// insert channel
+	channelCreated, err := channel.SaveNotificationChannel(server.DB, tid_uuid)
+
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusCreated, channelCreated)
+}
+
+func (server *Server) ListNotificationChannels(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	channel := models.NotificationChannel{}
+
+	channels, err := channel.FindAllNotificationChannels(server.DB, tenant_id, r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, channels)
+}
+
+func (server *Server) ShowNotificationChannel(w http.ResponseWriter, r *http.Request) {
+
+	// get channel id
+	vars := mux.Vars(r)
+	channel_id := vars["channel_id"]
+
+	channel := models.NotificationChannel{}
+
+	c, err := channel.GetNotificationChannel(server.DB, channel_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, c)
+}
+
+func (server *Server) UpdateNotificationChannel(w http.ResponseWriter, r *http.Request) {
+
+	// get channel id
+	vars := mux.Vars(r)
+	channel_id := vars["channel_id"]
+
+	// get body info
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// get channel model
+	channel := models.NotificationChannel{}
+	err = json.Unmarshal(body, &channel)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// prepares channel details for the database insertion
+	channel.PrepareUpdate()
+	channel.KeepSecrets(server.DB, channel_id)
+
+	// validate json fields
+	var validations formaterror.GeneralError = channel.NotificationChannelValidations()
+	if len(validations.Errors) > 0 {
+		responses.JSON(w, http.StatusUnprocessableEntity, validations)
+		return
+	}
+
+	c, err := channel.UpdateNotificationChannel(server.DB, channel_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, c)
+}
+
+func (server *Server) DeleteNotificationChannel(w http.ResponseWriter, r *http.Request) {
+
+	// get channel id
+	vars := mux.Vars(r)
+	channel_id := vars["channel_id"]
+
+	channel := models.NotificationChannel{}
+
+	err := channel.DeleteNotificationChannel(server.DB, channel_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"siot/api/auth"
+	"siot/api/models"
+	"siot/api/responses"
+	"siot/api/serializers"
+)
+
+// OIDCLogin starts the single sign-on and returns the authorization url of the
+// identity provider
+func (server *Server) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+
+	if server.OIDC == nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("single sign-on is not configured"))
+		return
+	}
+
+	authorizationURL, err := models.StartOIDCLogin(server.DB, server.OIDC)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadGateway, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, map[string]string{
+		"authorization_url": authorizationURL,
+	})
+}
+
+// OIDCCallback receives the code and state of the identity provider and returns
+// the same response as the login
+func (server *Server) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+
+	if server.OIDC == nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("single sign-on is not configured"))
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New(query.Get("error")+" "+query.Get("error_description")))
+		return
+	}
+
+	user, err := models.CompleteOIDCLogin(server.DB, server.OIDC, query.Get("code"), query.Get("state"), clientIP(r))
+	if loginLocked(w, err) {
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	// users with two-factor authentication still exchange a code
+	if user.MFAEnabled {
+		mfaToken, err := auth.CreateMFAToken(user.ID)
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		responses.JSON(w, http.StatusOK, serializers.MFAChallengeSerializer{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int64(auth.MFATokenTTL.Seconds()),
+		})
+		return
+	}
+
+	tokens, err := models.IssueTokens(server.DB, user)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// user information
+	userDetails, err := user.FindUserByID(server.DB, user.ID.String())
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var resp serializers.LoginSerializer
+	resp.Token = tokens.AccessToken
+	resp.RefreshToken = tokens.RefreshToken
+	resp.ExpiresIn = tokens.ExpiresIn
+	resp.User = userDetails.ShowUserSerializer()
+
+	responses.JSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"siot/api/auth"
+	"siot/api/models"
+	"siot/api/responses"
+	"siot/api/utils/formaterror"
+)
+
+// ForgotPassword emails a reset token, it always responds the same so it can not
+// be used to find out which emails have an account
+func (server *Server) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+
+	// get json body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	var forgot struct {
+		Email string `json:"email"`
+	}
+	err = json.Unmarshal(body, &forgot)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if forgot.Email == "" {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("email is required"))
+		return
+	}
+
+	err = models.RequestPasswordReset(server.DB, forgot.Email, clientIP(r))
+	if loginLocked(w, err) {
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusAccepted, map[string]string{
+		"message": "if the email has an account, a reset link was sent to it",
+	})
+}
+
+// ResetPassword sets a new password with the token of the reset email, the token
+// is only read from the body so it does not end up in the access logs
+func (server *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
+
+	// get json body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	var reset struct {
+		ResetToken string `json:"reset_token"`
+		Password   string `json:"password"`
+	}
+	err = json.Unmarshal(body, &reset)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	err = models.ResetPassword(server.DB, reset.ResetToken, reset.Password)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePassword replaces the password of the logged user. Every session of the
+// user is closed and new tokens are returned.
+func (server *Server) ChangePassword(w http.ResponseWriter, r *http.Request) {
+
+	user_id, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	// get json body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	var change struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+	err = json.Unmarshal(body, &change)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	user := models.User{}
+	u, err := user.FindUserByID(server.DB, user_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
+	// validate password strength
+	candidate := models.User{Email: u.Email, Password: change.Password}
+	var validations formaterror.GeneralError = candidate.UserValidations("password", server.DB)
+	if len(validations.Errors) > 0 {
+		responses.JSON(w, http.StatusUnprocessableEntity, validations)
+		return
+	}
+
+	err = user.ChangePassword(server.DB, user_id, change.CurrentPassword, change.Password)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	tokens, err := models.IssueTokens(server.DB, &user)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, tokens)
+}
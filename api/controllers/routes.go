@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"siot/api/middlewares"
+	"siot/api/models"
 )
 
 func (s *Server) initializeRoutes() {
@@ -12,73 +13,108 @@ func (s *Server) initializeRoutes() {
 	// Login Route
 	s.Router.HandleFunc("/api/login", middlewares.SetMiddlewareJSON(s.Login)).Methods("POST")
 
+	s.Router.HandleFunc("/api/login/mfa", middlewares.SetMiddlewareJSON(s.LoginMFA)).Methods("POST")
+
+	// Single sign-on routes
+	s.Router.HandleFunc("/api/oidc/login", middlewares.SetMiddlewareJSON(s.OIDCLogin)).Methods("GET")
+
+	s.Router.HandleFunc("/api/oidc/callback", middlewares.SetMiddlewareJSON(s.OIDCCallback)).Methods("GET")
+
+	s.Router.HandleFunc("/api/token/refresh", middlewares.SetMiddlewareJSON(s.RefreshToken)).Methods("POST")
+
+	s.Router.HandleFunc("/api/logout", middlewares.SetMiddlewareAuthentication(s.DB, s.Logout)).Methods("POST")
+
+	// Password routes
+	s.Router.HandleFunc("/api/password/forgot", middlewares.SetMiddlewareJSON(s.ForgotPassword)).Methods("POST")
+
+	s.Router.HandleFunc("/api/password/reset", middlewares.SetMiddlewareJSON(s.ResetPassword)).Methods("POST")
+
+	s.Router.HandleFunc("/api/me/password", middlewares.SetMiddlewareAuthentication(s.DB, s.ChangePassword)).Methods("PUT")
+
+	// Two-factor authentication routes
+	s.Router.HandleFunc("/api/me/mfa", middlewares.SetMiddlewareAuthentication(s.DB, s.EnrollMFA)).Methods("POST")
+
+	s.Router.HandleFunc("/api/me/mfa/verify", middlewares.SetMiddlewareAuthentication(s.DB, s.VerifyMFA)).Methods("POST")
+
+	s.Router.HandleFunc("/api/me/mfa", middlewares.SetMiddlewareAuthentication(s.DB, s.DisableMFA)).Methods("DELETE")
+
 	// Confirmation user
 	s.Router.HandleFunc("/api/users/confirmation", middlewares.SetMiddlewareJSON(s.ConfirmUser)).Methods("PUT")
 
 	// Super admin routes
 	// Users routes
 	s.Router.HandleFunc("/api/users", middlewares.SetMiddlewareAuthentication(
-		middlewares.SetMiddlewareIsSuperAdmin(s.DB, s.CreateAdminUser))).Methods("POST")
+		s.DB, middlewares.SetMiddlewareIsSuperAdmin(s.DB, s.CreateAdminUser))).Methods("POST")
 
-	// Admin user
+	s.Router.HandleFunc("/api/users/{user_id}/status", middlewares.SetMiddlewareAuthentication(
+		s.DB, middlewares.SetMiddlewareIsSuperAdmin(s.DB, s.UpdateUserStatus))).Methods("PUT")
+
+	s.Router.HandleFunc("/api/users/{user_id}/lockout", middlewares.SetMiddlewareAuthentication(
+		s.DB, middlewares.SetMiddlewareIsSuperAdmin(s.DB, s.UnlockUser))).Methods("DELETE")
+
+	// Metrics routes
+	s.Router.HandleFunc("/api/metrics/rules", middlewares.SetMiddlewareAuthentication(
+		s.DB, middlewares.SetMiddlewareIsSuperAdmin(s.DB, s.RuleMetrics))).Methods("GET")
+
+	// Tenant members
 	// Users routes
 	s.Router.HandleFunc("/api/{tenant_id}/users", middlewares.SetMiddlewareAuthentication(
-		middlewares.SetMiddlewareIsAdmin(
-			s.DB, middlewares.SetMiddlewareIsTenantValid(s.DB, s.AddUser)))).Methods("POST")
+		s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionMembersWrite, s.AddUser))).Methods("POST")
 
 	// Tenants routes
 	s.Router.HandleFunc("/api/tenants",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsAdmin(s.DB, s.CreateTenant))).Methods("POST")
+			s.DB, middlewares.SetMiddlewareIsAdmin(s.DB, s.CreateTenant))).Methods("POST")
 
 	s.Router.HandleFunc("/api/tenants",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsAdmin(s.DB, s.ListTenants))).Methods("GET")
+			s.DB, middlewares.SetMiddlewareIsAdmin(s.DB, s.ListTenants))).Methods("GET")
 
 	s.Router.HandleFunc("/api/tenants/{tenant_id}",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsAdmin(
-				s.DB, middlewares.SetMiddlewareIsTenantValid(s.DB, s.GetTenant)))).Methods("GET")
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionTenantRead, s.GetTenant))).Methods("GET")
 
 	s.Router.HandleFunc("/api/tenants/{tenant_id}",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsAdmin(
-				s.DB, middlewares.SetMiddlewareIsTenantValid(s.DB, s.UpdateTenant)))).Methods("PUT")
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionTenantWrite, s.UpdateTenant))).Methods("PUT")
 
-	// Non admin users
-	// Users routes
 	s.Router.HandleFunc("/api/{tenant_id}/users",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(s.DB, s.GetTenantUsers))).Methods("GET")
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionMembersRead, s.GetTenantUsers))).Methods("GET")
 
 	s.Router.HandleFunc("/api/{tenant_id}/users/{user_id}",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsUserTenantValid(s.DB, s.GetTenantUser)))).Methods("GET")
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionMembersRead, middlewares.SetMiddlewareIsUserTenantValid(s.DB, s.GetTenantUser)))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/users/{user_id}/role",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionMembersWrite, middlewares.SetMiddlewareIsUserTenantValid(s.DB, s.UpdateTenantUserRole)))).Methods("PUT")
 
 	// Devices routes
 	s.Router.HandleFunc("/api/{tenant_id}/devices",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(s.DB, s.CreateDevice))).Methods("POST")
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionDevicesWrite, s.CreateDevice))).Methods("POST")
 
 	s.Router.HandleFunc("/api/{tenant_id}/devices",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(s.DB, s.ListDevices))).Methods("GET")
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionDevicesRead, s.ListDevices))).Methods("GET")
 
 	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.ShowDevice)))).Methods("GET")
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionDevicesRead, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.ShowDevice)))).Methods("GET")
 
 	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.UpdateDevice)))).Methods("PUT")
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionDevicesWrite, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.UpdateDevice)))).Methods("PUT")
 
 	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.DeleteDevice)))).Methods("DELETE")
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionDevicesWrite, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.DeleteDevice)))).Methods("DELETE")
 
 	// Data routes
 	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/data",
@@ -86,59 +122,223 @@ func (s *Server) initializeRoutes() {
 
 	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/data",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.GetData)))).Methods("GET")
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionDataRead, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.GetData)))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/data/aggregate",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionDataRead, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.AggregateData)))).Methods("GET")
+
+	// Commands routes
+	// device side, authenticated with the device secret key
+	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/commands/pending",
+		middlewares.SetMiddlewareIsDeviceValidAndActive(s.DB, s.PollCommands)).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/commands/{command_id}/ack",
+		middlewares.SetMiddlewareIsDeviceValidAndActive(
+			s.DB, middlewares.SetMiddlewareIsCommandValid(s.DB, s.AckCommand))).Methods("PUT")
+
+	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/commands",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionCommandsWrite, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.CreateCommand)))).Methods("POST")
+
+	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/commands",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionCommandsRead, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.ListCommands)))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/commands/{command_id}",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionCommandsRead, middlewares.SetMiddlewareIsDeviceValid(
+					s.DB, middlewares.SetMiddlewareIsCommandValid(s.DB, s.ShowCommand))))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/commands/{command_id}",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionCommandsWrite, middlewares.SetMiddlewareIsDeviceValid(
+					s.DB, middlewares.SetMiddlewareIsCommandValid(s.DB, s.DeleteCommand))))).Methods("DELETE")
+
+	// Api keys routes
+	s.Router.HandleFunc("/api/{tenant_id}/api-keys",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionApiKeysWrite, s.CreateApiKey))).Methods("POST")
+
+	s.Router.HandleFunc("/api/{tenant_id}/api-keys",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionApiKeysRead, s.ListApiKeys))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/api-keys/{api_key_id}",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionApiKeysWrite, middlewares.SetMiddlewareIsApiKeyValid(s.DB, s.RevokeApiKey)))).Methods("DELETE")
 
 	// Sensors routes
 	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/sensors",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.CreateSensor)))).Methods("POST")
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionSensorsWrite, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.CreateSensor)))).Methods("POST")
 
 	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/sensors",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.ListSensors)))).Methods("GET")
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionSensorsRead, middlewares.SetMiddlewareIsDeviceValid(s.DB, s.ListSensors)))).Methods("GET")
 
 	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/sensors/{sensor_id}",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsDeviceValid(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionSensorsRead, middlewares.SetMiddlewareIsDeviceValid(
 					s.DB, middlewares.SetMiddlewareIsSensorValid(s.DB, s.ShowSensor))))).Methods("GET")
 
 	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/sensors/{sensor_id}",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsDeviceValid(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionSensorsWrite, middlewares.SetMiddlewareIsDeviceValid(
 					s.DB, middlewares.SetMiddlewareIsSensorValid(s.DB, s.DeleteSensor))))).Methods("DELETE")
 
 	s.Router.HandleFunc("/api/{tenant_id}/devices/{device_id}/sensors/{sensor_id}",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsDeviceValid(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionSensorsWrite, middlewares.SetMiddlewareIsDeviceValid(
 					s.DB, middlewares.SetMiddlewareIsSensorValid(s.DB, s.UpdateSensor))))).Methods("PUT")
 
 	// Roles routes
 	s.Router.HandleFunc("/api/{tenant_id}/rules",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(s.DB, s.CreateRule))).Methods("POST")
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesWrite, s.CreateRule))).Methods("POST")
 
 	s.Router.HandleFunc("/api/{tenant_id}/rules",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(s.DB, s.ListRules))).Methods("GET")
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesRead, s.ListRules))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/rules/preview",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesRead, s.PreviewRule))).Methods("POST")
+
+	s.Router.HandleFunc("/api/{tenant_id}/rules/test",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesRead, s.TestRule))).Methods("POST")
 
 	s.Router.HandleFunc("/api/{tenant_id}/rules/{rule_id}",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsRuleValid(s.DB, s.ShowRule)))).Methods("GET")
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesRead, middlewares.SetMiddlewareIsRuleValid(s.DB, s.ShowRule)))).Methods("GET")
 
 	s.Router.HandleFunc("/api/{tenant_id}/rules/{rule_id}",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsRuleValid(s.DB, s.UpdateRule)))).Methods("PUT")
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesWrite, middlewares.SetMiddlewareIsRuleValid(s.DB, s.UpdateRule)))).Methods("PUT")
 
 	s.Router.HandleFunc("/api/{tenant_id}/rules/{rule_id}",
 		middlewares.SetMiddlewareAuthentication(
-			middlewares.SetMiddlewareIsTenantValid(
-				s.DB, middlewares.SetMiddlewareIsRuleValid(s.DB, s.DeleteRule)))).Methods("DELETE")
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesWrite, middlewares.SetMiddlewareIsRuleValid(s.DB, s.DeleteRule)))).Methods("DELETE")
+
+	s.Router.HandleFunc("/api/{tenant_id}/rules/{rule_id}/secret/rotate",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesWrite, middlewares.SetMiddlewareIsRuleValid(s.DB, s.RotateRuleSecret)))).Methods("POST")
+
+	s.Router.HandleFunc("/api/{tenant_id}/rules/{rule_id}/events",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesRead, middlewares.SetMiddlewareIsRuleValid(s.DB, s.ListRuleEvents)))).Methods("GET")
+
+	// Notification channels routes
+	s.Router.HandleFunc("/api/{tenant_id}/channels",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesWrite, s.CreateNotificationChannel))).Methods("POST")
+
+	s.Router.HandleFunc("/api/{tenant_id}/channels",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesRead, s.ListNotificationChannels))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/channels/{channel_id}",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesRead, middlewares.SetMiddlewareIsNotificationChannelValid(s.DB, s.ShowNotificationChannel)))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/channels/{channel_id}",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesWrite, middlewares.SetMiddlewareIsNotificationChannelValid(s.DB, s.UpdateNotificationChannel)))).Methods("PUT")
+
+	s.Router.HandleFunc("/api/{tenant_id}/channels/{channel_id}",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesWrite, middlewares.SetMiddlewareIsNotificationChannelValid(s.DB, s.DeleteNotificationChannel)))).Methods("DELETE")
+
+	// Escalation policies routes
+	s.Router.HandleFunc("/api/{tenant_id}/escalation-policies",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesWrite, s.CreateEscalationPolicy))).Methods("POST")
+
+	s.Router.HandleFunc("/api/{tenant_id}/escalation-policies",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesRead, s.ListEscalationPolicies))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/escalation-policies/{policy_id}",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesRead, middlewares.SetMiddlewareIsEscalationPolicyValid(s.DB, s.ShowEscalationPolicy)))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/escalation-policies/{policy_id}",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesWrite, middlewares.SetMiddlewareIsEscalationPolicyValid(s.DB, s.UpdateEscalationPolicy)))).Methods("PUT")
+
+	s.Router.HandleFunc("/api/{tenant_id}/escalation-policies/{policy_id}",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesWrite, middlewares.SetMiddlewareIsEscalationPolicyValid(s.DB, s.DeleteEscalationPolicy)))).Methods("DELETE")
+
+	// Maintenance windows routes
+	s.Router.HandleFunc("/api/{tenant_id}/maintenance-windows",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesWrite, s.CreateMaintenanceWindow))).Methods("POST")
+
+	s.Router.HandleFunc("/api/{tenant_id}/maintenance-windows",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesRead, s.ListMaintenanceWindows))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/maintenance-windows/{window_id}",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesWrite, middlewares.SetMiddlewareIsMaintenanceWindowValid(s.DB, s.DeleteMaintenanceWindow)))).Methods("DELETE")
+
+	// Events routes
+	s.Router.HandleFunc("/api/{tenant_id}/events",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesRead, s.ListRuleEvents))).Methods("GET")
+
+	// Deliveries routes
+	s.Router.HandleFunc("/api/{tenant_id}/deliveries",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesRead, s.ListDeliveries))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/dead-letters",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesRead, s.ListDeadLetters))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/dead-letters/{delivery_id}/replay",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesWrite, middlewares.SetMiddlewareIsDeliveryValid(s.DB, s.ReplayDeadLetter)))).Methods("POST")
+
+	// Alerts routes
+	s.Router.HandleFunc("/api/{tenant_id}/alerts",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(s.DB, models.PermissionRulesRead, s.ListAlerts))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/alerts/{alert_id}",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionRulesRead, middlewares.SetMiddlewareIsAlertValid(s.DB, s.ShowAlert)))).Methods("GET")
+
+	s.Router.HandleFunc("/api/{tenant_id}/alerts/{alert_id}/ack",
+		middlewares.SetMiddlewareAuthentication(
+			s.DB, middlewares.SetMiddlewareHasPermission(
+				s.DB, models.PermissionAlertsWrite, middlewares.SetMiddlewareIsAlertValid(s.DB, s.AcknowledgeAlert)))).Methods("PUT")
 }
@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+
+	"github.com/gorilla/mux"
+)
+
+func (server *Server) ListRuleEvents(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant and rule id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+	rule_id := vars["rule_id"]
+
+	event := models.RuleEvent{}
+
+	events, err := event.FindAllRuleEvents(server.DB, tenant_id, rule_id, r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, events)
+}
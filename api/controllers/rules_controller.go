@@ -50,8 +50,125 @@ func (server *Server) CreateRule(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
 	}
+	server.Evaluator.InvalidateRules()
 
-	responses.JSON(w, http.StatusCreated, ruleCreated)
+	responses.JSON(w, http.StatusCreated, models.RuleWithSecret{Rule: ruleCreated, SigningSecret: ruleCreated.SigningSecret})
+}
+
+// TestRule replays the stored data of the device through a rule that is not saved,
+// no notifications are sent
+func (server *Server) TestRule(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	// convert tenant id to uuid
+	tid_uuid, _ := uuid.Parse(tenant_id)
+
+	// get body info
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// get rule model and the range to replay
+	rule := models.Rule{}
+	err = json.Unmarshal(body, &rule)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	var dataRange struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	json.Unmarshal(body, &dataRange)
+
+	// validate json fields
+	rule.PrepareUpdate()
+	var validations formaterror.GeneralError = rule.RuleValidations(server.DB, tid_uuid)
+	if dataRange.From == "" {
+		validations.Errors = append(validations.Errors, "from is required")
+	}
+	if dataRange.To == "" {
+		validations.Errors = append(validations.Errors, "to is required")
+	}
+	if len(validations.Errors) > 0 {
+		responses.JSON(w, http.StatusUnprocessableEntity, validations)
+		return
+	}
+
+	rule.TenantID = tid_uuid
+
+	// group rules are replayed on one of their devices, ?device_id= selects it
+	device_id, err := rule.SampleDevice(server.DB, r.URL.Query().Get("device_id"))
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	result, err := rule.Backtest(server.MDB, device_id, dataRange.From, dataRange.To)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, result)
+}
+
+// PreviewRule renders the notification templates of a rule that is not saved with
+// the latest data of its device
+func (server *Server) PreviewRule(w http.ResponseWriter, r *http.Request) {
+
+	// get tenant id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+
+	// convert tenant id to uuid
+	tid_uuid, _ := uuid.Parse(tenant_id)
+
+	// get body info
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// get rule model
+	rule := models.Rule{}
+	err = json.Unmarshal(body, &rule)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// validate json fields
+	rule.PrepareUpdate()
+	var validations formaterror.GeneralError = rule.RuleValidations(server.DB, tid_uuid)
+	if len(validations.Errors) > 0 {
+		responses.JSON(w, http.StatusUnprocessableEntity, validations)
+		return
+	}
+
+	rule.TenantID = tid_uuid
+
+	// group rules are previewed with one of their devices, ?device_id= selects it
+	device_id, err := rule.SampleDevice(server.DB, r.URL.Query().Get("device_id"))
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	rendered, err := rule.PreviewTemplates(server.MDB, server.DB, device_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, rendered)
 }
 
 func (server *Server) ListRules(w http.ResponseWriter, r *http.Request) {
@@ -126,9 +243,27 @@ func (server *Server) UpdateRule(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
+	server.Evaluator.InvalidateRules()
 	responses.JSON(w, http.StatusOK, ru)
 }
 
+func (server *Server) RotateRuleSecret(w http.ResponseWriter, r *http.Request) {
+
+	// get rule id
+	vars := mux.Vars(r)
+	rule_id := vars["rule_id"]
+
+	rule := models.Rule{}
+
+	ru, err := rule.RotateSigningSecret(server.DB, rule_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	server.Evaluator.InvalidateRules()
+	responses.JSON(w, http.StatusOK, models.RuleWithSecret{Rule: ru, SigningSecret: ru.SigningSecret})
+}
+
 func (server *Server) DeleteRule(w http.ResponseWriter, r *http.Request) {
 
 	// get rule id
@@ -142,5 +277,6 @@ func (server *Server) DeleteRule(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
+	server.Evaluator.InvalidateRules()
 	w.WriteHeader(http.StatusNoContent)
 }
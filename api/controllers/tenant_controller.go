@@ -121,6 +121,17 @@ func (server *Server) UpdateTenant(w http.ResponseWriter, r *http.Request) {
 	// prepares device details for the database insertion
 	tenant.PrepareUpdate()
 
+	// the user requiring two-factor authentication must have it, or would lose the access
+	if tenant.RequireMFA != nil && *tenant.RequireMFA && auth.ExtractApiKey(r) == "" {
+		user_id, _ := auth.ExtractTokenID(r)
+		user := models.User{}
+		u, err := user.FindUserByID(server.DB, user_id)
+		if err != nil || !u.MFAEnabled {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("enable two-factor authentication before requiring it"))
+			return
+		}
+	}
+
 	t, err := tenant.UpdateTenant(server.DB, tenant_id)
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
@@ -2,9 +2,11 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 
+	"siot/api/auth"
 	"siot/api/models"
 	"siot/api/responses"
 	"siot/api/utils/formaterror"
@@ -68,11 +70,22 @@ func (server *Server) AddUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// get role of the new member
+	userTenant := models.UserTenant{}
+	err = json.Unmarshal(body, &userTenant)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
 	// prepares user details for the database insertion
 	user.Prepare()
 
 	// validate json fields
 	var validations formaterror.GeneralError = user.UserValidations("create", server.DB)
+	if userTenant.Role != "" && !models.IsValidRole(userTenant.Role) {
+		validations.Errors = append(validations.Errors, "invalid role. The available roles are: owner, admin, editor, viewer and device-operator")
+	}
 	if len(validations.Errors) > 0 {
 		responses.JSON(w, http.StatusUnprocessableEntity, validations)
 		return
@@ -85,7 +98,16 @@ func (server *Server) AddUser(w http.ResponseWriter, r *http.Request) {
 	// convert tenant id to uuid
 	tid_uuid, _ := uuid.Parse(tenant_id)
 
-	userCreated, err := user.SaveUserTenant(server.DB, tid_uuid)
+	// only owners can add other owners
+	if userTenant.Role == models.RoleOwner {
+		currentRole, _ := server.currentRole(r, tid_uuid)
+		if currentRole != models.RoleOwner {
+			responses.ERROR(w, http.StatusForbidden, errors.New("only owners can add other owners"))
+			return
+		}
+	}
+
+	userCreated, err := user.SaveUserTenant(server.DB, tid_uuid, userTenant.Role)
 	if err != nil {
 		formattedError := formaterror.FormatError(err.Error())
 		responses.ERROR(w, http.StatusUnprocessableEntity, formattedError)
@@ -132,6 +154,60 @@ func (server *Server) GetTenantUser(w http.ResponseWriter, r *http.Request) {
 	responses.JSON(w, http.StatusOK, userInfo.ShowUserSerializer())
 }
 
+func (server *Server) UpdateTenantUserRole(w http.ResponseWriter, r *http.Request) {
+
+	// get body info
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+	}
+
+	// get user tenant model
+	userTenant := models.UserTenant{}
+	err = json.Unmarshal(body, &userTenant)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// get tenant and user id
+	vars := mux.Vars(r)
+	tenant_id := vars["tenant_id"]
+	user_id := vars["user_id"]
+
+	// convert tenant and user id to uuid
+	tid_uuid, _ := uuid.Parse(tenant_id)
+	uid_uuid, _ := uuid.Parse(user_id)
+
+	currentRole, err := server.currentRole(r, tid_uuid)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	ut, err := userTenant.UpdateRole(server.DB, uid_uuid, tid_uuid, currentRole)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, ut)
+}
+
+// currentRole returns the role of the authenticated user inside the tenant
+func (server *Server) currentRole(r *http.Request, tenant_id uuid.UUID) (string, error) {
+
+	user_id, err := auth.ExtractTokenID(r)
+	if err != nil {
+		return "", err
+	}
+
+	// convert user id to uuid
+	uid_uuid, _ := uuid.Parse(user_id)
+
+	userTenant := models.UserTenant{}
+	return userTenant.GetRole(server.DB, uid_uuid, tenant_id)
+}
+
 func (server *Server) ConfirmUser(w http.ResponseWriter, r *http.Request) {
 
 	// get body info
@@ -162,3 +238,53 @@ func (server *Server) ConfirmUser(w http.ResponseWriter, r *http.Request) {
 	}
 	responses.JSON(w, http.StatusOK, users.ShowUserSerializer())
 }
+
+// UpdateUserStatus activates or deactivates a user, the tokens of the user are revoked
+func (server *Server) UpdateUserStatus(w http.ResponseWriter, r *http.Request) {
+
+	// get user id
+	vars := mux.Vars(r)
+	user_id := vars["user_id"]
+
+	// get body info
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	var status struct {
+		Status string `json:"status"`
+	}
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	user := models.User{}
+
+	u, err := user.UpdateStatus(server.DB, user_id, status.Status)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, u.ShowUserSerializer())
+}
+
+// UnlockUser removes the lockout of the failed login attempts of the user
+func (server *Server) UnlockUser(w http.ResponseWriter, r *http.Request) {
+
+	// get user id
+	vars := mux.Vars(r)
+	user_id := vars["user_id"]
+
+	user := models.User{}
+
+	err := user.UnlockUser(server.DB, user_id)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+func SetMiddlewareIsAlertValid(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// get tenant and alert id
+		vars := mux.Vars(r)
+		tenant_id := vars["tenant_id"]
+		alert_id := vars["alert_id"]
+
+		// convert tenant and alert id to uuid
+		tid_uuid, _ := uuid.Parse(tenant_id)
+		aid_uuid, err := uuid.Parse(alert_id)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("invalid alert id"))
+			return
+		}
+
+		alert := models.Alert{}
+
+		isAlertValid, _ := alert.IsValidAlert(db, tid_uuid, aid_uuid)
+
+		if !isAlertValid {
+			responses.ERROR(w, http.StatusNotFound, errors.New("alert not found"))
+			return
+		}
+
+		next(w, r)
+	}
+}
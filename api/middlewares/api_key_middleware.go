@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+func SetMiddlewareIsApiKeyValid(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// get tenant and api key id
+		vars := mux.Vars(r)
+		tenant_id := vars["tenant_id"]
+		api_key_id := vars["api_key_id"]
+
+		// convert tenant and api key id to uuid
+		tid_uuid, _ := uuid.Parse(tenant_id)
+		kid_uuid, err := uuid.Parse(api_key_id)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("invalid api key id"))
+			return
+		}
+
+		apiKey := models.ApiKey{}
+
+		isApiKeyValid, _ := apiKey.IsValidApiKey(db, tid_uuid, kid_uuid)
+
+		if !isApiKeyValid {
+			responses.ERROR(w, http.StatusNotFound, errors.New("api key not found"))
+			return
+		}
+
+		next(w, r)
+	}
+}
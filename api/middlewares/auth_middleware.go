@@ -19,10 +19,22 @@ func SetMiddlewareJSON(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func SetMiddlewareAuthentication(next http.HandlerFunc) http.HandlerFunc {
+func SetMiddlewareAuthentication(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
+		// tenant api keys are accepted instead of the user token
+		if key := auth.ExtractApiKey(r); key != "" {
+			apiKey := models.ApiKey{}
+			_, err := apiKey.FindActiveApiKey(db, key)
+			if err != nil {
+				responses.ERROR(w, http.StatusUnauthorized, err)
+				return
+			}
+			next(w, r)
+			return
+		}
+
 		err := auth.TokenValid(r)
 		if err != nil {
 			responses.ERROR(w, http.StatusUnauthorized, errors.New("invalid token"))
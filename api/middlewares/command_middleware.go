@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+func SetMiddlewareIsCommandValid(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// get device and command id
+		vars := mux.Vars(r)
+		device_id := vars["device_id"]
+		command_id := vars["command_id"]
+
+		// convert device and command id to uuid
+		did_uuid, _ := uuid.Parse(device_id)
+		cid_uuid, err := uuid.Parse(command_id)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("invalid command id"))
+			return
+		}
+
+		command := models.Command{}
+
+		isCommandValid, _ := command.IsValidCommand(db, cid_uuid, did_uuid)
+
+		if !isCommandValid {
+			responses.ERROR(w, http.StatusNotFound, errors.New("command not found"))
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+func SetMiddlewareIsDeliveryValid(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// get tenant and delivery id
+		vars := mux.Vars(r)
+		tenant_id := vars["tenant_id"]
+		delivery_id := vars["delivery_id"]
+
+		// convert tenant and delivery id to uuid
+		tid_uuid, _ := uuid.Parse(tenant_id)
+		did_uuid, err := uuid.Parse(delivery_id)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("invalid delivery id"))
+			return
+		}
+
+		delivery := models.Delivery{}
+
+		isDeliveryValid, _ := delivery.IsValidDelivery(db, tid_uuid, did_uuid)
+
+		if !isDeliveryValid {
+			responses.ERROR(w, http.StatusNotFound, errors.New("delivery not found"))
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+func SetMiddlewareIsEscalationPolicyValid(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// get tenant and policy id
+		vars := mux.Vars(r)
+		tenant_id := vars["tenant_id"]
+		policy_id := vars["policy_id"]
+
+		// convert tenant and policy id to uuid
+		tid_uuid, _ := uuid.Parse(tenant_id)
+		pid_uuid, err := uuid.Parse(policy_id)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("invalid escalation policy id"))
+			return
+		}
+
+		policy := models.EscalationPolicy{}
+
+		isPolicyValid, _ := policy.IsValidEscalationPolicy(db, tid_uuid, pid_uuid)
+
+		if !isPolicyValid {
+			responses.ERROR(w, http.StatusNotFound, errors.New("escalation policy not found"))
+			return
+		}
+
+		next(w, r)
+	}
+}
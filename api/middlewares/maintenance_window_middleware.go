@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+func SetMiddlewareIsMaintenanceWindowValid(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// get tenant and window id
+		vars := mux.Vars(r)
+		tenant_id := vars["tenant_id"]
+		window_id := vars["window_id"]
+
+		// convert tenant and window id to uuid
+		tid_uuid, _ := uuid.Parse(tenant_id)
+		wid_uuid, err := uuid.Parse(window_id)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("invalid maintenance window id"))
+			return
+		}
+
+		window := models.MaintenanceWindow{}
+
+		isWindowValid, _ := window.IsValidMaintenanceWindow(db, tid_uuid, wid_uuid)
+
+		if !isWindowValid {
+			responses.ERROR(w, http.StatusNotFound, errors.New("maintenance window not found"))
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"siot/api/models"
+	"siot/api/responses"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+func SetMiddlewareIsNotificationChannelValid(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// get tenant and channel id
+		vars := mux.Vars(r)
+		tenant_id := vars["tenant_id"]
+		channel_id := vars["channel_id"]
+
+		// convert tenant and channel id to uuid
+		tid_uuid, _ := uuid.Parse(tenant_id)
+		cid_uuid, err := uuid.Parse(channel_id)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("invalid channel id"))
+			return
+		}
+
+		channel := models.NotificationChannel{}
+
+		isChannelValid, _ := channel.IsValidNotificationChannel(db, tid_uuid, cid_uuid)
+
+		if !isChannelValid {
+			responses.ERROR(w, http.StatusNotFound, errors.New("channel not found"))
+			return
+		}
+
+		next(w, r)
+	}
+}
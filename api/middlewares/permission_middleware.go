@@ -0,0 +1,70 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"siot/api/auth"
+	"siot/api/models"
+	"siot/api/responses"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// SetMiddlewareHasPermission validates the tenant and checks that the role of the
+// user inside the tenant grants the given permission
+func SetMiddlewareHasPermission(db *gorm.DB, permission string, next http.HandlerFunc) http.HandlerFunc {
+	return SetMiddlewareIsTenantValid(db, func(w http.ResponseWriter, r *http.Request) {
+
+		// tenant api key, the scope of the key replaces the role
+		if key := auth.ExtractApiKey(r); key != "" {
+			apiKey := models.ApiKey{}
+			k, err := apiKey.FindActiveApiKey(db, key)
+			if err != nil {
+				responses.ERROR(w, http.StatusUnauthorized, err)
+				return
+			}
+
+			if !k.HasPermission(permission) {
+				responses.ERROR(w, http.StatusForbidden, errors.New("the scope of the api key does not allow this action"))
+				return
+			}
+
+			next(w, r)
+			return
+		}
+
+		// get user token
+		user_id, err := auth.ExtractTokenID(r)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			return
+		}
+
+		// convert user and tenant id to uuid
+		uid_uuid, _ := uuid.Parse(user_id)
+		tid_uuid, _ := uuid.Parse(mux.Vars(r)["tenant_id"])
+
+		user_tenant := models.UserTenant{}
+		role, err := user_tenant.GetRole(db, uid_uuid, tid_uuid)
+		if err != nil {
+			responses.ERROR(w, http.StatusNotFound, errors.New("tenant not found"))
+			return
+		}
+
+		if !models.RoleHasPermission(role, permission) {
+			responses.ERROR(w, http.StatusForbidden, errors.New("your role does not allow this action"))
+			return
+		}
+
+		// members of tenants that require it must enable two-factor authentication
+		if models.MissingMFA(db, uid_uuid, tid_uuid) {
+			responses.ERROR(w, http.StatusForbidden, errors.New("the tenant requires two-factor authentication, enable it in /api/me/mfa"))
+			return
+		}
+
+		next(w, r)
+	})
+}
@@ -18,6 +18,12 @@ func SetMiddlewareIsTenantValid(db *gorm.DB, next http.HandlerFunc) http.Handler
 
 		w.Header().Set("Content-Type", "application/json")
 
+		// tenant api key
+		if key := auth.ExtractApiKey(r); key != "" {
+			setMiddlewareIsApiKeyTenantValid(db, key, next)(w, r)
+			return
+		}
+
 		// get user token
 		user_id, err := auth.ExtractTokenID(r)
 		if err != nil {
@@ -58,3 +64,46 @@ func SetMiddlewareIsTenantValid(db *gorm.DB, next http.HandlerFunc) http.Handler
 		next(w, r)
 	}
 }
+
+func setMiddlewareIsApiKeyTenantValid(db *gorm.DB, key string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// get tenant id
+		vars := mux.Vars(r)
+		tenant_id := vars["tenant_id"]
+
+		// convert tenant id to uuid
+		tid_uuid, err := uuid.Parse(tenant_id)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("invalid tenant id"))
+			return
+		}
+
+		apiKey := models.ApiKey{}
+		k, err := apiKey.FindActiveApiKey(db, key)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnauthorized, err)
+			return
+		}
+
+		// keys only give access to their own tenant
+		if k.TenantID != tid_uuid {
+			responses.ERROR(w, http.StatusNotFound, errors.New("tenant not found"))
+			return
+		}
+
+		tenant := models.Tenant{}
+		isTenantActive, errTenant := tenant.IsActive(db, tid_uuid)
+		if errTenant != nil {
+			responses.ERROR(w, http.StatusNotFound, errors.New("tenant not found"))
+			return
+		}
+
+		if !isTenantActive {
+			responses.ERROR(w, http.StatusNotFound, errors.New("tenant is inactive"))
+			return
+		}
+
+		next(w, r)
+	}
+}
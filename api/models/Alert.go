@@ -0,0 +1,296 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"siot/api/utils/pagination"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	AlertStatePending  = "pending"
+	AlertStateFiring   = "firing"
+	AlertStateResolved = "resolved"
+)
+
+type Alert struct {
+	ID              uuid.UUID  `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	State           string     `gorm:"size:255;not null;" json:"state"`
+	Value           string     `gorm:"size:255;" json:"value"`
+	PendingSince    time.Time  `json:"pending_since"`
+	FiredAt         *time.Time `json:"fired_at"`
+	ResolvedAt      *time.Time `json:"resolved_at"`
+	AcknowledgedAt  *time.Time `json:"acknowledged_at"`
+	AcknowledgedBy  *uuid.UUID `sql:"type:uuid REFERENCES users(id) ON DELETE SET NULL" json:"acknowledged_by"`
+	EscalationLevel int        `gorm:"default:0" json:"escalation_level"`
+	LastNotifiedAt  *time.Time `json:"last_notified_at"`
+	RuleID          uuid.UUID  `sql:"type:uuid REFERENCES rules(id) ON DELETE CASCADE" json:"rule_id"`
+	DeviceID        uuid.UUID  `sql:"type:uuid REFERENCES devices(id) ON DELETE CASCADE" json:"device_id"`
+	TenantID        uuid.UUID  `sql:"type:uuid REFERENCES tenants(id) ON DELETE CASCADE" json:"-"`
+	CreatedAt       time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt       time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (a *Alert) BeforeCreate() {
+
+	a.CreatedAt = time.Now()
+	a.UpdatedAt = time.Now()
+}
+
+func (a *Alert) IsValidAlert(db *gorm.DB, tenant_id uuid.UUID, alert_id uuid.UUID) (bool, error) {
+
+	alerts := []Alert{}
+
+	// query
+	err := db.Where("tenant_id = ? AND id = ?", tenant_id, alert_id).Find(&alerts).Error
+	if err != nil {
+		return false, err
+	}
+
+	if len(alerts) > 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (a *Alert) FindAllAlerts(db *gorm.DB, tenant_id string, r *http.Request) (interface{}, error) {
+
+	alerts := []Alert{}
+
+	query := db.Where("tenant_id = ?", tenant_id)
+
+	// filters
+	if r.URL.Query().Get("state") != "" {
+		query = query.Where("state = ?", r.URL.Query().Get("state"))
+	}
+	if r.URL.Query().Get("rule_id") != "" {
+		query = query.Where("rule_id = ?", r.URL.Query().Get("rule_id"))
+	}
+	if r.URL.Query().Get("device_id") != "" {
+		query = query.Where("device_id = ?", r.URL.Query().Get("device_id"))
+	}
+
+	var count int
+
+	var err_count error = query.Find(&alerts).Count(&count).Error
+	if err_count != nil {
+		return nil, err_count
+	}
+
+	// pagination
+	offset, limit, page, totalPages, nextPage, previousPage, errPagination := pagination.ValidatePagination(r, count)
+	if errPagination != nil {
+		return nil, errPagination
+	}
+
+	// query
+	var err error = query.Limit(limit).Offset(offset).Order("updated_at desc").Find(&alerts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return pagination.ListPaginationSerializer(limit, page, count, totalPages, nextPage, previousPage, alerts), nil
+}
+
+func (a *Alert) GetAlert(db *gorm.DB, alert_id string) (*Alert, error) {
+
+	alert := Alert{}
+
+	// query
+	err := db.Model(&Alert{}).Where("id = ?", alert_id).Take(&alert).Error
+	if err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// findOpenAlert returns the pending or firing alert of a rule for a device, if any
+func findOpenAlert(db *gorm.DB, rule_id uuid.UUID, device_id uuid.UUID) *Alert {
+
+	alerts := []Alert{}
+	db.Where("rule_id = ? AND device_id = ? AND state IN (?)", rule_id, device_id, []string{AlertStatePending, AlertStateFiring}).Order("created_at desc").Limit(1).Find(&alerts)
+
+	if len(alerts) > 0 {
+		return &alerts[0]
+	}
+	return nil
+}
+
+// processAlert moves the alert of the rule for the device through its lifecycle.
+// The condition must hold for the rule "for" duration before the alert fires, and
+// a firing alert only resolves once the value leaves the threshold by the hysteresis.
+func (r *Rule) processAlert(db *gorm.DB, device_id uuid.UUID, value interface{}, lastData map[string]interface{}) error {
+
+	threshold := r.Value
+	if alert := findOpenAlert(db, r.ID, device_id); alert != nil && alert.State == AlertStateFiring {
+		threshold = r.resolveThreshold()
+	}
+
+	holds := conditionHolds(r.Operator, value, threshold)
+	return r.updateAlert(db, device_id, holds, fmt.Sprintf("%v", value), lastData)
+}
+
+// updateAlert moves the alert of the rule for the device through its lifecycle
+// once the condition of the rule has been evaluated
+func (r *Rule) updateAlert(db *gorm.DB, device_id uuid.UUID, holds bool, stringValue string, lastData map[string]interface{}) error {
+
+	alert := findOpenAlert(db, r.ID, device_id)
+	now := time.Now()
+
+	if !holds {
+		if alert == nil {
+			return nil
+		}
+
+		// the condition did not hold long enough to fire
+		if alert.State == AlertStatePending {
+			return db.Delete(alert).Error
+		}
+
+		var err error = db.Model(alert).Updates(map[string]interface{}{
+			"state":       AlertStateResolved,
+			"value":       stringValue,
+			"resolved_at": now,
+			"updated_at":  now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		alert.Value = stringValue
+		r.notify(db, alert, lastData, AlertStateResolved)
+		return nil
+	}
+
+	if alert == nil {
+		alert = &Alert{
+			State:        AlertStatePending,
+			Value:        stringValue,
+			PendingSince: now,
+			RuleID:       r.ID,
+			DeviceID:     device_id,
+			TenantID:     r.TenantID,
+		}
+
+		var err error = db.Create(alert).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if alert.State == AlertStatePending {
+
+		// wait for the condition to hold during the "for" duration
+		forDuration, _ := durationFromString(r.For)
+		if now.Sub(alert.PendingSince) < forDuration {
+			return db.Model(alert).Updates(map[string]interface{}{"value": stringValue, "updated_at": now}).Error
+		}
+
+		var err error = db.Model(alert).Updates(map[string]interface{}{
+			"state":      AlertStateFiring,
+			"value":      stringValue,
+			"fired_at":   now,
+			"updated_at": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		alert.State = AlertStateFiring
+		alert.Value = stringValue
+		alert.FiredAt = &now
+		r.notify(db, alert, lastData, AlertStateFiring)
+		return nil
+	}
+
+	// still firing, repeat the notification if configured
+	var err error = db.Model(alert).Updates(map[string]interface{}{"value": stringValue, "updated_at": now}).Error
+	if err != nil {
+		return err
+	}
+
+	alert.Value = stringValue
+	if r.TimeBetweenNotification != "" && freeNotificationTime(r.TimeBetweenNotification, r.lastNotificationOf(alert)) {
+		r.notify(db, alert, lastData, AlertStateFiring)
+		return nil
+	}
+
+	// notify the escalation steps that became due
+	return r.escalate(db, alert)
+}
+
+// lastNotificationOf is the last notification of the alert device. Group rules
+// repeat the notifications of each device on its own, a device rule keeps the
+// time of the rule across its alerts.
+func (r *Rule) lastNotificationOf(alert *Alert) time.Time {
+
+	if r.targetsDevice() {
+		return r.LastNotification
+	}
+	if alert.LastNotifiedAt == nil {
+		return time.Time{}
+	}
+	return *alert.LastNotifiedAt
+}
+
+// updateNotificationTime only writes the column of the alert
+func (a *Alert) updateNotificationTime(db *gorm.DB) error {
+
+	now := time.Now()
+	a.LastNotifiedAt = &now
+	return db.Model(&Alert{}).Where("id = ?", a.ID).UpdateColumn("last_notified_at", now).Error
+}
+
+// resolveThreshold shifts the threshold by the hysteresis so a firing alert does
+// not flap when the value oscillates around it
+func (r *Rule) resolveThreshold() string {
+
+	ruleValue, err := strconv.ParseFloat(r.Value, 64)
+	if err != nil || r.Hysteresis == 0 {
+		return r.Value
+	}
+
+	switch r.Operator {
+	case "gt", "gte":
+		return strconv.FormatFloat(ruleValue-r.Hysteresis, 'f', -1, 64)
+	case "lt", "lte":
+		return strconv.FormatFloat(ruleValue+r.Hysteresis, 'f', -1, 64)
+	}
+
+	return r.Value
+}
+
+// conditionHolds compares a device value with the rule value. Numbers are compared
+// as floats and any other value can only be compared for equality.
+func conditionHolds(operator string, value interface{}, ruleValue string) bool {
+
+	stringValue := fmt.Sprintf("%v", value)
+
+	threshold, errRule := strconv.ParseFloat(ruleValue, 64)
+	deviceValue, errDevice := strconv.ParseFloat(stringValue, 64)
+
+	if errRule != nil || errDevice != nil {
+		if operator == "gt" || operator == "gte" || operator == "lt" || operator == "lte" {
+			return false
+		}
+		return stringValue == ruleValue
+	}
+
+	switch operator {
+	case "gt":
+		return deviceValue > threshold
+	case "gte":
+		return deviceValue >= threshold
+	case "lt":
+		return deviceValue < threshold
+	case "lte":
+		return deviceValue <= threshold
+	}
+
+	return deviceValue == threshold
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html"
+	"net/http"
+	"siot/api/utils/formaterror"
+	"siot/api/utils/pagination"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ApiKeyScopeRead  = "read"
+	ApiKeyScopeWrite = "write"
+)
+
+type ApiKey struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	Name       string     `gorm:"size:255;not null;" json:"name"`
+	Prefix     string     `gorm:"size:255;not null;" json:"prefix"`
+	KeyHash    string     `gorm:"size:255;not null;unique" json:"-"`
+	Key        string     `gorm:"-" json:"key,omitempty"`
+	Scope      string     `gorm:"size:255;not null;" json:"scope"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	TenantID   uuid.UUID  `sql:"type:uuid REFERENCES tenants(id) ON DELETE CASCADE" json:"-"`
+	CreatedBy  uuid.UUID  `sql:"type:uuid" json:"created_by"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (k *ApiKey) BeforeCreate() {
+
+	k.Name = html.EscapeString(strings.TrimSpace(k.Name))
+	k.Scope = strings.ToLower(strings.TrimSpace(k.Scope))
+	k.CreatedAt = time.Now()
+	k.UpdatedAt = time.Now()
+
+	if k.Scope != ApiKeyScopeRead && k.Scope != ApiKeyScopeWrite {
+		k.Scope = ApiKeyScopeRead
+	}
+}
+
+func (k *ApiKey) ApiKeyValidations() formaterror.GeneralError {
+
+	var errors formaterror.GeneralError
+
+	if k.Name == "" {
+		errors.Errors = append(errors.Errors, "name is required")
+	}
+	if len(k.Name) > 255 {
+		errors.Errors = append(errors.Errors, "name is too long")
+	}
+	if k.Scope != "" && k.Scope != ApiKeyScopeRead && k.Scope != ApiKeyScopeWrite {
+		errors.Errors = append(errors.Errors, "invalid scope. The available scopes are: read and write")
+	}
+	if k.ExpiresAt != nil && k.ExpiresAt.Before(time.Now()) {
+		errors.Errors = append(errors.Errors, "expires_at must be in the future")
+	}
+	return errors
+}
+
+// HasPermission checks the permission against the scope of the key. Keys can not
+// manage the tenant, its members or other keys.
+func (k *ApiKey) HasPermission(permission string) bool {
+
+	if permission == PermissionTenantWrite || permission == PermissionMembersWrite ||
+		permission == PermissionApiKeysRead || permission == PermissionApiKeysWrite {
+		return false
+	}
+
+	if k.Scope == ApiKeyScopeWrite {
+		return true
+	}
+
+	for _, p := range readPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// SaveApiKey generates the key, only its hash is stored and the plain key is returned once
+func (k *ApiKey) SaveApiKey(db *gorm.DB, tenant_id uuid.UUID, user_id uuid.UUID) (*ApiKey, error) {
+
+	k.TenantID = tenant_id
+	k.CreatedBy = user_id
+
+	k.Prefix = "siot_" + randStr(4)
+	k.Key = k.Prefix + "_" + randStr(24)
+	k.KeyHash = hashApiKey(k.Key)
+
+	// create api key
+	err := db.Model(&ApiKey{}).Create(&k).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (k *ApiKey) FindAllApiKeys(db *gorm.DB, tenant_id string, r *http.Request) (interface{}, error) {
+
+	apiKeys := []ApiKey{}
+
+	var count int
+
+	var err_count error = db.Where("tenant_id = ?", tenant_id).Find(&apiKeys).Count(&count).Error
+	if err_count != nil {
+		return nil, err_count
+	}
+
+	// pagination
+	offset, limit, page, totalPages, nextPage, previousPage, errPagination := pagination.ValidatePagination(r, count)
+	if errPagination != nil {
+		return nil, errPagination
+	}
+
+	// query
+	var err error = db.Where("tenant_id = ?", tenant_id).Limit(limit).Offset(offset).Order("created_at desc").Find(&apiKeys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return pagination.ListPaginationSerializer(limit, page, count, totalPages, nextPage, previousPage, apiKeys), nil
+}
+
+func (k *ApiKey) IsValidApiKey(db *gorm.DB, tenant_id uuid.UUID, api_key_id uuid.UUID) (bool, error) {
+
+	apiKeys := []ApiKey{}
+
+	// query
+	err := db.Where("tenant_id = ? AND id = ?", tenant_id, api_key_id).Find(&apiKeys).Error
+	if err != nil {
+		return false, err
+	}
+
+	if len(apiKeys) > 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (k *ApiKey) RevokeApiKey(db *gorm.DB, api_key_id string) error {
+
+	var err error = db.Model(&ApiKey{}).Where("id = ? AND revoked_at IS NULL", api_key_id).Updates(map[string]interface{}{
+		"revoked_at": time.Now(),
+		"updated_at": time.Now(),
+	}).Error
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// FindActiveApiKey returns the key if it exists, is not revoked and is not expired
+func (k *ApiKey) FindActiveApiKey(db *gorm.DB, key string) (*ApiKey, error) {
+
+	var err error = db.Where("key_hash = ?", hashApiKey(key)).Take(&k).Error
+	if err != nil {
+		return nil, errors.New("invalid api key")
+	}
+
+	if k.RevokedAt != nil {
+		return nil, errors.New("api key is revoked")
+	}
+
+	if k.ExpiresAt != nil && k.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("api key is expired")
+	}
+
+	// record usage
+	db.Model(&ApiKey{}).Where("id = ?", k.ID).UpdateColumn("last_used_at", time.Now())
+
+	return k, nil
+}
@@ -0,0 +1,240 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maximum number of documents replayed by a backtest
+const maxBacktestData = 10000
+
+type BacktestEvent struct {
+	CollectedAt string `json:"collected_at"`
+	State       string `json:"state"`
+	Value       string `json:"value"`
+}
+
+// BacktestResult lists when a rule would have fired and resolved in a range of data
+type BacktestResult struct {
+	From      string          `json:"from"`
+	To        string          `json:"to"`
+	Evaluated int             `json:"evaluated"`
+	Fired     int             `json:"fired"`
+	Truncated bool            `json:"truncated"`
+	Events    []BacktestEvent `json:"events"`
+}
+
+// backtestAlert keeps the alert state of the replay in memory, it follows the
+// same lifecycle as processAlert without storing alerts or sending notifications
+type backtestAlert struct {
+	rule         *Rule
+	result       *BacktestResult
+	state        string
+	pendingSince time.Time
+}
+
+func (a *backtestAlert) update(holds bool, value string, at time.Time) {
+
+	collectedAt := at.Format("2006-01-02T15:04:05.000Z")
+
+	if !holds {
+		if a.state == AlertStateFiring {
+			a.result.Events = append(a.result.Events, BacktestEvent{CollectedAt: collectedAt, State: AlertStateResolved, Value: value})
+		}
+		a.state = ""
+		return
+	}
+
+	if a.state == "" {
+		a.state = AlertStatePending
+		a.pendingSince = at
+	}
+
+	if a.state == AlertStatePending {
+		forDuration, _ := durationFromString(a.rule.For)
+		if at.Sub(a.pendingSince) < forDuration {
+			return
+		}
+
+		a.state = AlertStateFiring
+		a.result.Fired++
+		a.result.Events = append(a.result.Events, BacktestEvent{CollectedAt: collectedAt, State: AlertStateFiring, Value: value})
+	}
+}
+
+// Backtest replays the stored data of the device between from and to through the
+// rule. Only the data inside the range is used to compute aggregations.
+func (r *Rule) Backtest(dbm *mongo.Client, device_id uuid.UUID, from string, to string) (*BacktestResult, error) {
+
+	fromDate, errFrom := time.Parse("2006-01-02T15:04:05.000Z", from)
+	toDate, errTo := time.Parse("2006-01-02T15:04:05.000Z", to)
+	if errFrom != nil || errTo != nil {
+		return nil, errors.New("from and to must have the format 2006-01-02T15:04:05.000Z")
+	}
+	if !fromDate.Before(toDate) {
+		return nil, errors.New("from must be before to")
+	}
+
+	// filter params, newest first like the live evaluation
+	var opt options.FindOptions
+	opt.SetLimit(maxBacktestData + 1)
+	opt.SetSort(bson.M{"collected_at": -1})
+
+	filter := bson.M{"collected_at": bson.M{"$gte": from, "$lte": to}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	collection := dbm.Database("siot").Collection(fmt.Sprintf("%v", device_id))
+	cur, err := collection.Find(ctx, filter, &opt)
+	if err != nil {
+		return nil, err
+	}
+
+	var data Data
+	if err = cur.All(ctx, &data.Data); err != nil {
+		return nil, errors.New("error returning data")
+	}
+
+	result := BacktestResult{From: from, To: to, Events: []BacktestEvent{}}
+
+	// keep the newest data when the range has too many documents
+	if len(data.Data) > maxBacktestData {
+		data.Data = data.Data[:maxBacktestData]
+		result.Truncated = true
+	}
+
+	alert := backtestAlert{rule: r, result: &result}
+
+	if r.Type == RuleTypeNoData {
+		r.backtestNoData(data.Data, fromDate, toDate, &alert)
+		return &result, nil
+	}
+
+	tree, err := r.conditionTree()
+	if err != nil {
+		return nil, err
+	}
+
+	var sensors []string
+	if tree != nil {
+		sensors = tree.sensors()
+	} else {
+		sensors = []string{r.Sensor}
+	}
+
+	// replay from the oldest document, data[i:] is the history at that moment
+	for i := len(data.Data) - 1; i >= 0; i-- {
+
+		if !documentHasSensor(data.Data[i], sensors) {
+			continue
+		}
+
+		at, err := time.Parse("2006-01-02T15:04:05.000Z", fmt.Sprintf("%v", data.Data[i]["collected_at"]))
+		if err != nil {
+			continue
+		}
+
+		// the data outside the schedule is not evaluated, the alert keeps its state
+		if !r.isScheduledAt(at) {
+			continue
+		}
+
+		history := data.Data[i:]
+
+		if tree != nil {
+			values := map[string]interface{}{}
+			holds, _ := tree.evaluate(func(c Condition) (interface{}, bool, error) {
+				value, ok := conditionValue(c, history, at)
+				return value, ok, nil
+			}, values)
+
+			result.Evaluated++
+			alert.update(holds, formatConditionValues(values), at)
+			continue
+		}
+
+		value, ok := conditionValue(r.leafCondition(), history, at)
+		if !ok {
+			continue
+		}
+
+		threshold := r.Value
+		if alert.state == AlertStateFiring {
+			threshold = r.resolveThreshold()
+		}
+
+		result.Evaluated++
+		alert.update(conditionHolds(r.Operator, value, threshold), fmt.Sprintf("%v", value), at)
+	}
+
+	return &result, nil
+}
+
+// backtestNoData fires at every gap between two values of the sensor longer than
+// the rule window and resolves when the next value arrives
+func (r *Rule) backtestNoData(data []map[string]interface{}, from time.Time, to time.Time, alert *backtestAlert) {
+
+	window, _ := durationFromString(r.Window)
+	lastSeen := from
+
+	for i := len(data) - 1; i >= 0; i-- {
+
+		if _, ok := data[i][r.Sensor]; !ok {
+			continue
+		}
+
+		at, err := time.Parse("2006-01-02T15:04:05.000Z", fmt.Sprintf("%v", data[i]["collected_at"]))
+		if err != nil {
+			continue
+		}
+
+		r.backtestSilence(lastSeen, at, window, alert)
+		lastSeen = at
+
+		// new data only resolves the alert while the rule is scheduled
+		if r.isScheduledAt(at) {
+			alert.result.Evaluated++
+			alert.update(false, at.Format("2006-01-02T15:04:05.000Z"), at)
+		}
+	}
+
+	r.backtestSilence(lastSeen, to, window, alert)
+}
+
+// backtestSilence evaluates the rule while the sensor was silent between two times
+func (r *Rule) backtestSilence(lastSeen time.Time, until time.Time, window time.Duration, alert *backtestAlert) {
+
+	// the scheduler only notices the silence while the rule is scheduled
+	silentAt, ok := r.nextScheduledAt(lastSeen.Add(window), until)
+	if !ok {
+		return
+	}
+
+	value := lastSeen.Format("2006-01-02T15:04:05.000Z")
+	alert.update(true, value, silentAt)
+
+	// the alert fires once the "for" duration is over
+	forDuration, _ := durationFromString(r.For)
+	if forDuration > 0 {
+		if firesAt, ok := r.nextScheduledAt(silentAt.Add(forDuration), until); ok {
+			alert.update(true, value, firesAt)
+		}
+	}
+}
+
+func documentHasSensor(document map[string]interface{}, sensors []string) bool {
+
+	for _, sensor := range sensors {
+		if _, ok := document[sensor]; ok {
+			return true
+		}
+	}
+	return false
+}
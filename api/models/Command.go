@@ -0,0 +1,221 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+	"siot/api/utils/formaterror"
+	"siot/api/utils/pagination"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+type Command struct {
+	ID          uuid.UUID  `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	Payload     JSONB      `sql:"type:jsonb" json:"payload"`
+	Response    JSONB      `sql:"type:jsonb" json:"response"`
+	Status      string     `gorm:"size:255;default:'pending'" json:"status"`
+	TTL         string     `gorm:"size:255;" json:"ttl"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	DeliveredAt *time.Time `json:"delivered_at"`
+	AckedAt     *time.Time `json:"acked_at"`
+	DeviceID    uuid.UUID  `sql:"type:uuid REFERENCES devices(id) ON DELETE CASCADE" json:"device_id"`
+	TenantID    uuid.UUID  `sql:"type:uuid REFERENCES tenants(id) ON DELETE CASCADE" json:"-"`
+	RuleID      *uuid.UUID `sql:"type:uuid" json:"rule_id"`
+	CreatedAt   time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (c *Command) BeforeCreate() {
+
+	c.TTL = strings.TrimSpace(c.TTL)
+	c.Status = "pending"
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+
+	// commands without ttl never expire
+	if ttl, err := durationFromString(c.TTL); err == nil && ttl > 0 {
+		expiresAt := c.CreatedAt.Add(ttl)
+		c.ExpiresAt = &expiresAt
+	}
+}
+
+func (c *Command) CommandValidations() formaterror.GeneralError {
+
+	var errors formaterror.GeneralError
+
+	if len(c.Payload) == 0 {
+		errors.Errors = append(errors.Errors, "payload is required")
+	}
+	if c.TTL != "" {
+		if _, err := durationFromString(strings.TrimSpace(c.TTL)); err != nil {
+			errors.Errors = append(errors.Errors, "invalid ttl")
+		}
+	}
+	return errors
+}
+
+func (c *Command) IsValidCommand(db *gorm.DB, command_id uuid.UUID, device_id uuid.UUID) (bool, error) {
+
+	commands := []Command{}
+
+	// query
+	err := db.Where("id = ? AND device_id = ?", command_id, device_id).Find(&commands).Error
+	if err != nil {
+		return false, err
+	}
+
+	if len(commands) > 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (c *Command) SaveCommand(db *gorm.DB, tenant_id uuid.UUID, device_id uuid.UUID) (*Command, error) {
+
+	c.TenantID = tenant_id
+	c.DeviceID = device_id
+
+	// create command
+	err := db.Model(&Command{}).Create(&c).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Command) FindAllCommands(db *gorm.DB, device_id string, r *http.Request) (interface{}, error) {
+
+	expireCommands(db, device_id)
+
+	commands := []Command{}
+
+	query := db.Where("device_id = ?", device_id)
+
+	// filter by status
+	if r.URL.Query().Get("status") != "" {
+		query = query.Where("status = ?", r.URL.Query().Get("status"))
+	}
+
+	var count int
+
+	var err_count error = query.Find(&commands).Count(&count).Error
+	if err_count != nil {
+		return nil, err_count
+	}
+
+	// pagination
+	offset, limit, page, totalPages, nextPage, previousPage, errPagination := pagination.ValidatePagination(r, count)
+	if errPagination != nil {
+		return nil, errPagination
+	}
+
+	// query
+	var err error = query.Limit(limit).Offset(offset).Order("created_at desc").Find(&commands).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return pagination.ListPaginationSerializer(limit, page, count, totalPages, nextPage, previousPage, commands), nil
+}
+
+func (c *Command) GetCommand(db *gorm.DB, command_id string) (*Command, error) {
+
+	command := Command{}
+
+	// query
+	err := db.Model(&Command{}).Where("id = ?", command_id).Take(&command).Error
+	if err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+func (c *Command) DeleteCommand(db *gorm.DB, command_id string) error {
+
+	var err error = db.Where("id = ?", command_id).Delete(&Command{}).Error
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// PollCommands returns the pending commands of a device and marks them as delivered.
+// The commands are claimed in one statement, concurrent polls never get the same command.
+func (c *Command) PollCommands(db *gorm.DB, device_id string) ([]Command, error) {
+
+	expireCommands(db, device_id)
+
+	commands := []Command{}
+	now := time.Now()
+
+	var err error = db.Raw(`UPDATE commands SET status = ?, delivered_at = ?, updated_at = ?
+		WHERE status = ? AND id IN (
+			SELECT id FROM commands WHERE device_id = ? AND status = ? FOR UPDATE SKIP LOCKED
+		) RETURNING *`, "delivered", now, now, "pending", device_id, "pending").Scan(&commands).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not keep any order
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].CreatedAt.Before(commands[j].CreatedAt)
+	})
+
+	return commands, nil
+}
+
+// AckCommand marks a delivered command as acknowledged by the device
+func (c *Command) AckCommand(db *gorm.DB, command_id string) (*Command, error) {
+
+	now := time.Now()
+
+	updates := map[string]interface{}{
+		"status":     "acked",
+		"acked_at":   now,
+		"updated_at": now,
+	}
+
+	if len(c.Response) > 0 {
+		updates["response"] = c.Response
+	}
+
+	// only delivered commands are acknowledged, and only once
+	update := db.Model(&Command{}).Where("id = ? AND status = ?", command_id, "delivered").Updates(updates)
+	if update.Error != nil {
+		return nil, update.Error
+	}
+
+	command, err := c.GetCommand(db, command_id)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.RowsAffected != 1 {
+		switch command.Status {
+		case "expired":
+			return nil, errors.New("command is expired")
+		case "acked":
+			return nil, errors.New("command already acknowledged")
+		default:
+			return nil, errors.New("command was not delivered")
+		}
+	}
+
+	return command, nil
+}
+
+// expireCommands marks the commands that were not acknowledged before their ttl as expired
+func expireCommands(db *gorm.DB, device_id string) {
+
+	db.Model(&Command{}).Where("device_id = ? AND status IN (?) AND expires_at < ?", device_id, []string{"pending", "delivered"}, time.Now()).Updates(map[string]interface{}{
+		"status":     "expired",
+		"updated_at": time.Now(),
+	})
+}
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ConditionOpAnd = "and"
+	ConditionOpOr  = "or"
+
+	maxConditionDepth = 5
+)
+
+// Condition is a node of the condition tree of a rule. A node either combines its
+// conditions with "and"/"or" or compares the value of a sensor, e.g.
+//
+//	{"op": "and", "conditions": [
+//		{"sensor": "temperature", "operator": "gt", "value": 30},
+//		{"sensor": "humidity", "operation": "mean", "window": "15m", "operator": "lt", "value": 20}
+//	]}
+type Condition struct {
+	Op          string      `json:"op,omitempty"`
+	Conditions  []Condition `json:"conditions,omitempty"`
+	Sensor      string      `json:"sensor,omitempty"`
+	Operator    string      `json:"operator,omitempty"`
+	Value       interface{} `json:"value,omitempty"`
+	Operation   string      `json:"operation,omitempty"`
+	CountLatest int64       `json:"count_latest,omitempty"`
+	Window      string      `json:"window,omitempty"`
+	MinSamples  int64       `json:"min_samples,omitempty"`
+	Percentile  float64     `json:"percentile,omitempty"`
+}
+
+// conditionTree decodes the conditions of the rule, it is nil for single sensor rules
+func (r *Rule) conditionTree() (*Condition, error) {
+
+	if len(r.Conditions) == 0 {
+		return nil, nil
+	}
+
+	conditions, _ := json.Marshal(r.Conditions)
+
+	var tree Condition
+	if err := json.Unmarshal(conditions, &tree); err != nil {
+		return nil, errors.New("invalid conditions")
+	}
+	return &tree, nil
+}
+
+// watchesAnySensor checks if the condition tree uses any of the sensors
+func (r *Rule) watchesAnySensor(sensors []string) bool {
+
+	tree, err := r.conditionTree()
+	if err != nil || tree == nil {
+		return false
+	}
+
+	for _, sensor := range tree.sensors() {
+		for _, s := range sensors {
+			if sensor == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Condition) isGroup() bool {
+	return c.Op != ""
+}
+
+func (c *Condition) sensors() []string {
+
+	if !c.isGroup() {
+		return []string{c.Sensor}
+	}
+
+	var sensors []string
+	for i := 0; i < len(c.Conditions); i++ {
+		sensors = append(sensors, c.Conditions[i].sensors()...)
+	}
+	return sensors
+}
+
+// validate returns the errors of the node and its children, path locates the node
+// in the messages e.g. conditions.1.operator. The sensor names are not checked for
+// group rules, which pass uuid.Nil as device.
+func (c *Condition) validate(db *gorm.DB, device_id uuid.UUID, path string, depth int) []string {
+
+	var errs []string
+
+	if depth > maxConditionDepth {
+		return append(errs, fmt.Sprintf("%v is nested too deep, the maximum depth is %v", path, maxConditionDepth))
+	}
+
+	if c.isGroup() {
+		if c.Op != ConditionOpAnd && c.Op != ConditionOpOr {
+			errs = append(errs, fmt.Sprintf("invalid %v.op. The available ops are: and and or", path))
+		}
+		if len(c.Conditions) < 1 {
+			errs = append(errs, fmt.Sprintf("%v.conditions is required", path))
+		}
+		for i := 0; i < len(c.Conditions); i++ {
+			errs = append(errs, c.Conditions[i].validate(db, device_id, fmt.Sprintf("%v.conditions.%v", path, i), depth+1)...)
+		}
+		return errs
+	}
+
+	var sensor Sensor
+	if c.Sensor == "" {
+		errs = append(errs, fmt.Sprintf("%v.sensor is required", path))
+	} else if device_id != uuid.Nil && !sensor.IsValidSensorName(db, c.Sensor, device_id) {
+		errs = append(errs, fmt.Sprintf("invalid %v.sensor name", path))
+	}
+
+	if c.Operator != "lt" && c.Operator != "lte" && c.Operator != "gt" && c.Operator != "gte" && c.Operator != "eq" {
+		errs = append(errs, fmt.Sprintf("invalid %v.operator. The available operators are: lt, lte, gt, gte and eq", path))
+	}
+	if c.Value == nil {
+		errs = append(errs, fmt.Sprintf("%v.value is required", path))
+	}
+
+	if c.Window != "" {
+		if _, err := durationFromString(c.Window); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid %v.window", path))
+		}
+	}
+	if c.MinSamples < 0 {
+		errs = append(errs, fmt.Sprintf("%v.min_samples can not be negative", path))
+	}
+
+	if c.Window != "" || c.CountLatest > 1 {
+		if !isValidOperation(c.Operation) {
+			errs = append(errs, fmt.Sprintf("invalid %v.operation. The available operations are: %v", path, availableOperations()))
+		}
+		if c.Operation == "percentile" && (c.Percentile <= 0 || c.Percentile > 100) {
+			errs = append(errs, fmt.Sprintf("%v.percentile must be between 0 and 100", path))
+		}
+	}
+
+	return errs
+}
+
+// evaluate checks the condition with the sensor values returned by valueOf. The
+// values used by the leaves are added to values so they can be reported in the alert.
+func (c *Condition) evaluate(valueOf func(Condition) (interface{}, bool, error), values map[string]interface{}) (bool, error) {
+
+	if !c.isGroup() {
+		value, ok, err := valueOf(*c)
+		if err != nil || !ok {
+			return false, err
+		}
+
+		values[c.label()] = value
+		return conditionHolds(c.Operator, value, fmt.Sprintf("%v", c.Value)), nil
+	}
+
+	// every condition is evaluated so the alert reports all the values
+	holds := c.Op == ConditionOpAnd
+	for i := 0; i < len(c.Conditions); i++ {
+		h, err := c.Conditions[i].evaluate(valueOf, values)
+		if err != nil {
+			return false, err
+		}
+
+		if c.Op == ConditionOpAnd {
+			holds = holds && h
+		} else {
+			holds = holds || h
+		}
+	}
+	return holds, nil
+}
+
+// label names the value of a leaf e.g. temperature, mean(temperature) or
+// mean(temperature, 15m)
+func (c *Condition) label() string {
+
+	if c.Window != "" {
+		return fmt.Sprintf("%v(%v, %v)", c.Operation, c.Sensor, c.Window)
+	}
+	if c.CountLatest > 1 {
+		return fmt.Sprintf("%v(%v)", c.Operation, c.Sensor)
+	}
+	return c.Sensor
+}
+
+// formatConditionValues formats the values of a condition tree as the alert value
+func formatConditionValues(values map[string]interface{}) string {
+
+	var labels []string
+	for label := range values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var parts []string
+	for _, label := range labels {
+		parts = append(parts, fmt.Sprintf("%v=%v", label, values[label]))
+	}
+
+	value := strings.Join(parts, ", ")
+	if len(value) > 255 {
+		value = value[:255]
+	}
+	return value
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"siot/api/utils/pagination"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,7 +21,27 @@ type Data struct {
 	Data []map[string]interface{} `validate:"required" json:"data" bson:"data"`
 }
 
-func (d *Data) ValidateAndSendData(dbm *mongo.Client, db *gorm.DB, device_id uuid.UUID) error {
+type AggregatedData struct {
+	Interval  string                              `json:"interval"`
+	Functions []string                            `json:"fn"`
+	From      string                              `json:"from"`
+	To        string                              `json:"to"`
+	Sensors   map[string][]map[string]interface{} `json:"sensors"`
+}
+
+var aggregationFunctions = map[string]string{
+	"min":   "$min",
+	"max":   "$max",
+	"mean":  "$avg",
+	"sum":   "$sum",
+	"count": "$sum",
+	"first": "$first",
+	"last":  "$last",
+}
+
+// ValidateAndSendData stores the data and queues the evaluation of the rules of the
+// device, the rules are checked in a new goroutine when there is no evaluator
+func (d *Data) ValidateAndSendData(dbm *mongo.Client, db *gorm.DB, device_id uuid.UUID, evaluator *RuleEvaluator) error {
 
 	device := Device{}
 	device_sensors, _ := device.FindDevice(db, device_id)
@@ -86,8 +108,14 @@ func (d *Data) ValidateAndSendData(dbm *mongo.Client, db *gorm.DB, device_id uui
 		return err
 	}
 
-	// check rules
-	go CheckRule(dbm, db, device_id, d.Data[len(d.Data)-1])
+	// check rules, the data is already stored when the evaluation is dropped
+	if evaluator != nil {
+		if err := evaluator.Enqueue(device_id, d.Data[len(d.Data)-1]); err != nil {
+			log.Printf("data: the rules of device %v are not evaluated: %v", device_id, err)
+		}
+	} else {
+		go CheckRule(dbm, db, device_id, d.Data[len(d.Data)-1])
+	}
 
 	return nil
 }
@@ -149,6 +177,122 @@ func (d *Data) GetData(dbm *mongo.Client, db *gorm.DB, device_id uuid.UUID, r *h
 	return d, nil
 }
 
+func (d *Data) AggregateData(dbm *mongo.Client, db *gorm.DB, device_id uuid.UUID, r *http.Request) (*AggregatedData, error) {
+
+	// bucket size
+	if r.URL.Query().Get("interval") == "" {
+		return nil, errors.New("interval is required")
+	}
+
+	interval, err := durationFromString(r.URL.Query().Get("interval"))
+	if err != nil || interval <= 0 {
+		return nil, errors.New("invalid interval")
+	}
+
+	// aggregation functions
+	functions := []string{"mean"}
+	if r.URL.Query().Get("fn") != "" {
+		functions = []string{}
+		for _, fn := range strings.Split(r.URL.Query().Get("fn"), ",") {
+			fn = strings.TrimSpace(fn)
+			if _, ok := aggregationFunctions[fn]; !ok {
+				return nil, errors.New("invalid fn. The available functions are: min, max, mean, sum, count, first and last")
+			}
+			if !stringInSlice(fn, functions) {
+				functions = append(functions, fn)
+			}
+		}
+	}
+
+	// filter by date
+	from, to := ValidateFromTo(r)
+
+	// only sensors of the device
+	sensors, _ := SetSensors(db, r, device_id)
+	if len(sensors) == 0 {
+		return nil, errors.New("at least one valid sensor is required")
+	}
+
+	result := AggregatedData{
+		Interval:  r.URL.Query().Get("interval"),
+		Functions: functions,
+		From:      from,
+		To:        to,
+		Sensors:   map[string][]map[string]interface{}{},
+	}
+
+	intervalMs := interval.Milliseconds()
+	collection := dbm.Database("siot").Collection(fmt.Sprintf("%v", device_id))
+
+	for _, sensor := range sensors {
+
+		if sensor == "collected_at" {
+			continue
+		}
+
+		// group by the start of the bucket in milliseconds
+		group := bson.M{
+			"_id": bson.M{"$subtract": bson.A{"$_ts", bson.M{"$mod": bson.A{"$_ts", intervalMs}}}},
+		}
+		for _, fn := range functions {
+			if fn == "count" {
+				group[fn] = bson.M{"$sum": 1}
+			} else {
+				group[fn] = bson.M{aggregationFunctions[fn]: "$" + sensor}
+			}
+		}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{
+				"collected_at": bson.M{"$gt": from, "$lt": to},
+				sensor:         bson.M{"$exists": true},
+			}}},
+			{{Key: "$addFields", Value: bson.M{
+				"_ts": bson.M{"$toLong": bson.M{"$dateFromString": bson.M{"dateString": "$collected_at"}}},
+			}}},
+			{{Key: "$sort", Value: bson.M{"_ts": 1}}},
+			{{Key: "$group", Value: group}},
+			{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		cur, err := collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		var buckets []bson.M
+		err = cur.All(ctx, &buckets)
+		cancel()
+		if err != nil {
+			return nil, errors.New("error returning data")
+		}
+
+		result.Sensors[sensor] = make([]map[string]interface{}, 0)
+
+		for _, bucket := range buckets {
+
+			start, ok := bucket["_id"].(int64)
+			if !ok {
+				continue
+			}
+
+			values := map[string]interface{}{
+				"start": time.Unix(0, start*int64(time.Millisecond)).UTC().Format("2006-01-02T15:04:05.000Z"),
+				"end":   time.Unix(0, (start+intervalMs)*int64(time.Millisecond)).UTC().Format("2006-01-02T15:04:05.000Z"),
+			}
+			for _, fn := range functions {
+				values[fn] = bucket[fn]
+			}
+
+			result.Sensors[sensor] = append(result.Sensors[sensor], values)
+		}
+	}
+
+	return &result, nil
+}
+
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
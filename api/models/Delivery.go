@@ -0,0 +1,498 @@
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"siot/api/utils/pagination"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusDead      = "dead"
+)
+
+// Delivery is an outgoing webhook request stored in the outbox until it is
+// delivered. The deliveries of a notification channel have no url, the payload
+// is the notification and it is sent with the current config of the channel.
+type Delivery struct {
+	ID             uuid.UUID  `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	Url            string     `gorm:"type:text;not null;" json:"url"`
+	Headers        JSONB      `sql:"type:jsonb" json:"headers"`
+	Payload        string     `gorm:"type:text;" json:"payload"`
+	Status         string     `gorm:"size:255;not null;" json:"status"`
+	Attempts       int        `gorm:"default:0" json:"attempts"`
+	MaxAttempts    int        `gorm:"default:0" json:"max_attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	LastStatusCode int        `gorm:"default:0" json:"last_status_code"`
+	LastError      string     `gorm:"type:text;" json:"last_error"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	RuleID         uuid.UUID  `sql:"type:uuid REFERENCES rules(id) ON DELETE CASCADE" json:"rule_id"`
+	RuleEventID    *uuid.UUID `sql:"type:uuid" json:"rule_event_id"`
+	ChannelID      *uuid.UUID `sql:"type:uuid REFERENCES notification_channels(id) ON DELETE CASCADE" json:"channel_id"`
+	TenantID       uuid.UUID  `sql:"type:uuid REFERENCES tenants(id) ON DELETE CASCADE" json:"-"`
+	CreatedAt      time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (d *Delivery) BeforeCreate() {
+
+	d.Status = DeliveryStatusPending
+	d.NextAttemptAt = time.Now()
+	d.CreatedAt = time.Now()
+	d.UpdatedAt = time.Now()
+
+	if d.MaxAttempts < 1 {
+		d.MaxAttempts = webhookMaxAttempts()
+	}
+}
+
+// webhookMaxAttempts is the number of attempts before a delivery goes to the dead letters
+func webhookMaxAttempts() int {
+
+	maxAttempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS"))
+	if err != nil || maxAttempts < 1 {
+		return 5
+	}
+	return maxAttempts
+}
+
+// webhookTimeout is the timeout of every webhook request
+func webhookTimeout() time.Duration {
+
+	timeout, err := durationFromString(os.Getenv("WEBHOOK_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return 10 * time.Second
+	}
+	return timeout
+}
+
+// webhookBackoff is the delay before the second attempt, it doubles on every retry
+func webhookBackoff() time.Duration {
+
+	backoff, err := durationFromString(os.Getenv("WEBHOOK_BACKOFF"))
+	if err != nil || backoff <= 0 {
+		return 10 * time.Second
+	}
+	return backoff
+}
+
+func (d *Delivery) SaveDelivery(db *gorm.DB) (*Delivery, error) {
+
+	var err error = db.Create(&d).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *Delivery) IsValidDelivery(db *gorm.DB, tenant_id uuid.UUID, delivery_id uuid.UUID) (bool, error) {
+
+	deliveries := []Delivery{}
+
+	// query
+	err := db.Where("tenant_id = ? AND id = ?", tenant_id, delivery_id).Find(&deliveries).Error
+	if err != nil {
+		return false, err
+	}
+
+	if len(deliveries) > 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (d *Delivery) FindAllDeliveries(db *gorm.DB, tenant_id string, status string, r *http.Request) (interface{}, error) {
+
+	deliveries := []Delivery{}
+
+	query := db.Where("tenant_id = ?", tenant_id)
+
+	// filters
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if r.URL.Query().Get("rule_id") != "" {
+		query = query.Where("rule_id = ?", r.URL.Query().Get("rule_id"))
+	}
+
+	var count int
+
+	var err_count error = query.Find(&deliveries).Count(&count).Error
+	if err_count != nil {
+		return nil, err_count
+	}
+
+	// pagination
+	offset, limit, page, totalPages, nextPage, previousPage, errPagination := pagination.ValidatePagination(r, count)
+	if errPagination != nil {
+		return nil, errPagination
+	}
+
+	// query
+	var err error = query.Limit(limit).Offset(offset).Order("created_at desc").Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return pagination.ListPaginationSerializer(limit, page, count, totalPages, nextPage, previousPage, deliveries), nil
+}
+
+// ReplayDelivery moves a dead delivery back to the outbox with a fresh set of attempts
+func (d *Delivery) ReplayDelivery(db *gorm.DB, delivery_id string) (*Delivery, error) {
+
+	var err error = db.Where("id = ?", delivery_id).Take(&d).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Status != DeliveryStatusDead {
+		return nil, errors.New("only dead deliveries can be replayed")
+	}
+
+	var errUpdate error = db.Model(&Delivery{}).Where("id = ?", delivery_id).Updates(map[string]interface{}{
+		"status":          DeliveryStatusPending,
+		"attempts":        0,
+		"max_attempts":    webhookMaxAttempts(),
+		"next_attempt_at": time.Now(),
+		"updated_at":      time.Now(),
+	}).Error
+	if errUpdate != nil {
+		return nil, errUpdate
+	}
+
+	var errGet error = db.Where("id = ?", delivery_id).Take(&d).Error
+	if errGet != nil {
+		return nil, errGet
+	}
+
+	return d, nil
+}
+
+// attempt sends the request once and updates the delivery with the result
+func (d *Delivery) attempt(db *gorm.DB, client *http.Client) error {
+
+	var statusCode int
+	var permanent bool
+	var errAttempt error
+
+	if d.ChannelID != nil {
+		statusCode, permanent, errAttempt = d.sendChannel(db, client)
+	} else {
+		// sign with the current secret of the rule so retries use a rotated secret
+		rule := Rule{}
+		db.Select("signing_secret").Where("id = ?", d.RuleID).Take(&rule)
+
+		statusCode, permanent, errAttempt = d.send(client, rule.SigningSecret)
+	}
+
+	now := time.Now()
+	d.recordAttempt(now, statusCode, errAttempt, permanent)
+
+	var errUpdate error = db.Model(&Delivery{}).Where("id = ?", d.ID).Updates(map[string]interface{}{
+		"status":           d.Status,
+		"attempts":         d.Attempts,
+		"last_status_code": d.LastStatusCode,
+		"last_error":       d.LastError,
+		"last_attempt_at":  now,
+		"next_attempt_at":  d.NextAttemptAt,
+		"delivered_at":     d.DeliveredAt,
+		"updated_at":       now,
+	}).Error
+	if errUpdate != nil {
+		return errUpdate
+	}
+
+	if d.Status != DeliveryStatusPending {
+		d.updateRuleEvent(db)
+	}
+
+	return errAttempt
+}
+
+// send posts the payload, signed when the rule has a secret. Permanent errors
+// are requests that can never succeed, they are not retried.
+func (d *Delivery) send(client *http.Client, secret string) (int, bool, error) {
+
+	req, err := http.NewRequest("POST", d.Url, bytes.NewBufferString(d.Payload))
+	if err != nil {
+		return 0, true, redactURLError(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range d.Headers {
+		req.Header.Set(key, fmt.Sprintf("%v", value))
+	}
+
+	if secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Siot-Timestamp", timestamp)
+		req.Header.Set("X-Siot-Signature", "sha256="+signPayload(secret, timestamp, d.Payload))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false, redactURLError(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, false, fmt.Errorf("endpoint responded with status %v", resp.StatusCode)
+	}
+
+	return resp.StatusCode, false, nil
+}
+
+// sendChannel sends the notification of the payload through the channel. Deleted
+// channels and invalid payloads are permanent errors.
+func (d *Delivery) sendChannel(db *gorm.DB, client *http.Client) (int, bool, error) {
+
+	channel := NotificationChannel{}
+	if db.Where("id = ?", *d.ChannelID).Take(&channel).Error != nil {
+		return 0, true, errors.New("notification channel not found")
+	}
+
+	notifier, err := channel.Notifier(client)
+	if err != nil {
+		return 0, true, err
+	}
+
+	n := Notification{}
+	if err := json.Unmarshal([]byte(d.Payload), &n); err != nil {
+		return 0, true, err
+	}
+
+	if err := notifier.Send(&n); err != nil {
+		return 0, false, err
+	}
+	return 0, false, nil
+}
+
+// recordAttempt applies the result of an attempt. Failures schedule the next
+// attempt with an exponential backoff, or move the delivery to the dead letters
+// when there are no attempts left.
+func (d *Delivery) recordAttempt(now time.Time, statusCode int, errAttempt error, permanent bool) {
+
+	d.Attempts++
+	d.LastStatusCode = statusCode
+	d.LastAttemptAt = &now
+
+	if errAttempt == nil {
+		d.Status = DeliveryStatusDelivered
+		d.LastError = ""
+		d.DeliveredAt = &now
+		return
+	}
+
+	d.LastError = errAttempt.Error()
+
+	if permanent || d.Attempts >= d.MaxAttempts {
+		d.Status = DeliveryStatusDead
+		return
+	}
+
+	backoff := webhookBackoff() * time.Duration(1<<uint(d.Attempts-1))
+	if backoff > time.Hour {
+		backoff = time.Hour
+	}
+	d.NextAttemptAt = now.Add(backoff)
+}
+
+// signPayload is the HMAC-SHA256 of "<timestamp>.<body>" with the signing secret
+// of the rule. Receivers recompute it and reject old timestamps to avoid replays.
+func signPayload(secret string, timestamp string, payload string) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// updateRuleEvent copies the final result of the delivery to the rule event
+func (d *Delivery) updateRuleEvent(db *gorm.DB) {
+
+	if d.RuleEventID == nil {
+		return
+	}
+
+	status := RuleEventStatusDelivered
+	if d.Status == DeliveryStatusDead {
+		status = RuleEventStatusFailed
+	}
+
+	db.Model(&RuleEvent{}).Where("id = ?", *d.RuleEventID).Updates(map[string]interface{}{
+		"status":     status,
+		"delivered":  d.Status == DeliveryStatusDelivered,
+		"error":      d.LastError,
+		"updated_at": time.Now(),
+	})
+}
+
+// DeliveryWorker periodically sends the pending deliveries of the outbox
+type DeliveryWorker struct {
+	DB          *gorm.DB
+	Client      *http.Client
+	Interval    time.Duration
+	BatchSize   int
+	Concurrency int
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewDeliveryWorker(db *gorm.DB) *DeliveryWorker {
+	return &DeliveryWorker{
+		DB:          db,
+		Client:      &http.Client{Timeout: webhookTimeout()},
+		Interval:    time.Second,
+		BatchSize:   50,
+		Concurrency: envInt("WEBHOOK_WORKERS", 8),
+	}
+}
+
+// Start runs the worker in the background until Stop is called
+func (w *DeliveryWorker) Start() {
+
+	w.stop = make(chan struct{})
+	w.wg.Add(1)
+
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(w.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.DeliverPending()
+			}
+		}
+	}()
+}
+
+// Stop waits for the current batch to finish
+func (w *DeliveryWorker) Stop() {
+
+	if w.stop == nil {
+		return
+	}
+
+	close(w.stop)
+	w.wg.Wait()
+}
+
+// DeliverPending attempts every pending delivery whose next attempt is due and
+// returns how many of them were attempted
+func (w *DeliveryWorker) DeliverPending() int {
+
+	deliveries := []Delivery{}
+
+	var err error = w.DB.Where("status = ? AND next_attempt_at <= ?", DeliveryStatusPending, time.Now()).Order("next_attempt_at asc").Limit(w.BatchSize).Find(&deliveries).Error
+	if err != nil {
+		log.Printf("deliveries: %v", err)
+		return 0
+	}
+
+	return w.deliver(deliveries, func(d *Delivery) error {
+		return d.attempt(w.DB, w.Client)
+	})
+}
+
+// deliver runs the attempts in a pool of Concurrency goroutines. The deliveries
+// of an endpoint are sent in order by the same goroutine, and the rest of them
+// wait for the next batch when one fails, so a dead endpoint only holds one
+// goroutine for one timeout.
+func (w *DeliveryWorker) deliver(deliveries []Delivery, attempt func(d *Delivery) error) int {
+
+	// group by endpoint keeping the order of the batch
+	var endpoints []string
+	groups := map[string][]*Delivery{}
+	for i := 0; i < len(deliveries); i++ {
+		endpoint := deliveries[i].endpoint()
+		if _, ok := groups[endpoint]; !ok {
+			endpoints = append(endpoints, endpoint)
+		}
+		groups[endpoint] = append(groups[endpoint], &deliveries[i])
+	}
+
+	concurrency := w.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	attempted := 0
+	sem := make(chan struct{}, concurrency)
+
+	for _, endpoint := range endpoints {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(group []*Delivery) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			for _, d := range group {
+				err := attempt(d)
+
+				mu.Lock()
+				attempted++
+				mu.Unlock()
+
+				if err != nil {
+					return
+				}
+			}
+		}(groups[endpoint])
+	}
+
+	wg.Wait()
+	return attempted
+}
+
+// endpoint groups the deliveries sent to the same place, a channel or the scheme
+// and host of the url
+func (d *Delivery) endpoint() string {
+
+	if d.ChannelID != nil {
+		return "channel:" + d.ChannelID.String()
+	}
+	return deliveryEndpoint(d.Url)
+}
+
+// deliveryEndpoint is the scheme and host of the url, the deliveries of an
+// endpoint share its backoff
+func deliveryEndpoint(rawurl string) string {
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeliverySignatureHeaders(t *testing.T) {
+
+	var got *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got, body = r, string(b)
+	}))
+	defer server.Close()
+
+	d := Delivery{Url: server.URL, Payload: `{"value":42}`, Headers: JSONB{"X-Custom": "yes"}}
+
+	statusCode, permanent, err := d.send(server.Client(), "s3cret")
+	if err != nil || permanent || statusCode != http.StatusOK {
+		t.Fatalf("expected a delivered request, got %v %v %v", statusCode, permanent, err)
+	}
+
+	if got.Header.Get("Content-Type") != "application/json" || got.Header.Get("X-Custom") != "yes" {
+		t.Fatalf("missing headers %v", got.Header)
+	}
+	if body != d.Payload {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	// receivers recompute the HMAC of "<timestamp>.<body>"
+	timestamp := got.Header.Get("X-Siot-Timestamp")
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "." + body))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if timestamp == "" || got.Header.Get("X-Siot-Signature") != expected {
+		t.Fatalf("invalid signature %q for timestamp %q", got.Header.Get("X-Siot-Signature"), timestamp)
+	}
+
+	// rules without a secret are not signed
+	if _, _, err := d.send(server.Client(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if got.Header.Get("X-Siot-Signature") != "" || got.Header.Get("X-Siot-Timestamp") != "" {
+		t.Fatal("unsigned rule sent a signature")
+	}
+}
+
+func TestDeliverySendErrors(t *testing.T) {
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		url        string
+		statusCode int
+		permanent  bool
+	}{
+		{"error status", failing.URL, http.StatusBadGateway, false},
+		{"timeout", slow.URL, 0, false},
+		{"connection refused", closed.URL, 0, false},
+		{"invalid url", "://missing-scheme", 0, true},
+	}
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Delivery{Url: tt.url, Payload: "{}"}
+			statusCode, permanent, err := d.send(client, "")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if statusCode != tt.statusCode || permanent != tt.permanent {
+				t.Fatalf("expected %v %v, got %v %v (%v)", tt.statusCode, tt.permanent, statusCode, permanent, err)
+			}
+		})
+	}
+}
+
+func TestDeliveryRetryBackoffAndDeadLetter(t *testing.T) {
+
+	now := time.Now()
+	d := Delivery{Status: DeliveryStatusPending, MaxAttempts: 4}
+
+	// 10s, 20s, 40s and then the dead letters
+	for i, backoff := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second} {
+		d.recordAttempt(now, http.StatusInternalServerError, errors.New("endpoint responded with status 500"), false)
+
+		if d.Status != DeliveryStatusPending || d.Attempts != i+1 {
+			t.Fatalf("attempt %v: unexpected status %v attempts %v", i+1, d.Status, d.Attempts)
+		}
+		if !d.NextAttemptAt.Equal(now.Add(backoff)) {
+			t.Fatalf("attempt %v: expected a backoff of %v, got %v", i+1, backoff, d.NextAttemptAt.Sub(now))
+		}
+	}
+
+	d.recordAttempt(now, http.StatusInternalServerError, errors.New("endpoint responded with status 500"), false)
+	if d.Status != DeliveryStatusDead || d.LastStatusCode != http.StatusInternalServerError || d.LastError == "" {
+		t.Fatalf("expected a dead delivery, got %+v", d)
+	}
+}
+
+func TestDeliveryBackoffCap(t *testing.T) {
+
+	now := time.Now()
+	d := Delivery{Status: DeliveryStatusPending, Attempts: 20, MaxAttempts: 100}
+
+	d.recordAttempt(now, 0, errors.New("timeout"), false)
+	if !d.NextAttemptAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expected the backoff to be capped at 1h, got %v", d.NextAttemptAt.Sub(now))
+	}
+}
+
+func TestDeliveryPermanentErrorIsDead(t *testing.T) {
+
+	d := Delivery{Status: DeliveryStatusPending, MaxAttempts: 5}
+
+	d.recordAttempt(time.Now(), 0, errors.New("invalid url"), true)
+	if d.Status != DeliveryStatusDead || d.Attempts != 1 {
+		t.Fatalf("expected a dead delivery after one attempt, got %v %v", d.Status, d.Attempts)
+	}
+}
+
+func TestDeliveryRetrySucceeds(t *testing.T) {
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	d := Delivery{Url: server.URL, Payload: "{}", Status: DeliveryStatusPending, MaxAttempts: 5}
+
+	for d.Status == DeliveryStatusPending {
+		statusCode, permanent, err := d.send(server.Client(), "")
+		d.recordAttempt(time.Now(), statusCode, err, permanent)
+	}
+
+	if d.Status != DeliveryStatusDelivered || d.Attempts != 3 || d.LastError != "" || d.DeliveredAt == nil {
+		t.Fatalf("expected a delivery on the third attempt, got %+v", d)
+	}
+}
+
+func TestDeliverConcurrentlyByEndpoint(t *testing.T) {
+
+	deliveries := []Delivery{
+		{Url: "http://dead.example/a"},
+		{Url: "http://dead.example/b"},
+		{Url: "http://dead.example/c"},
+		{Url: "http://one.example/hook"},
+		{Url: "http://two.example/hook"},
+		{Url: "http://three.example/hook"},
+	}
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	sent := map[string]bool{}
+	var running, maxRunning int32
+
+	w := &DeliveryWorker{Concurrency: 2}
+
+	done := make(chan int)
+	go func() {
+		done <- w.deliver(deliveries, func(d *Delivery) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+
+			mu.Lock()
+			sent[d.Url] = true
+			mu.Unlock()
+
+			// the dead endpoint hangs until the others are delivered
+			if deliveryEndpoint(d.Url) == "http://dead.example" {
+				<-release
+				return errors.New("timeout")
+			}
+			return nil
+		})
+	}()
+
+	// the other endpoints are not blocked by the dead one
+	deadline := time.After(5 * time.Second)
+	for {
+		mu.Lock()
+		others := sent["http://one.example/hook"] && sent["http://two.example/hook"] && sent["http://three.example/hook"]
+		mu.Unlock()
+		if others {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("the dead endpoint blocked the other endpoints")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	close(release)
+
+	attempted := <-done
+
+	// the rest of the dead endpoint waits for the next batch
+	if attempted != 4 || sent["http://dead.example/b"] || sent["http://dead.example/c"] {
+		t.Fatalf("expected 4 attempts without the queued dead deliveries, got %v %v", attempted, sent)
+	}
+	if maxRunning > 2 {
+		t.Fatalf("expected at most 2 concurrent attempts, got %v", maxRunning)
+	}
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"html"
 	"net/http"
@@ -30,6 +31,8 @@ type Device struct {
 	Longitude   float64   `validate:"required_with=Longitude,latitude" gorm:"type:decimal(11,8);default:0.0" json:"longitude"`
 	TenantID    uuid.UUID `sql:"type:uuid REFERENCES tenants(id)" json:"-"`
 	SecretKey   string    `gorm:"size:255;" json:"secret_key"`
+	Profile     string    `gorm:"size:255;" json:"profile"`
+	Tags        []string  `gorm:"-" json:"tags"`
 	Sensors     []Sensor  `gorm:"association_jointable_foreignkey:device_id, OnDelete:CASCADE" json:"sensors"`
 }
 
@@ -41,6 +44,10 @@ func (d *Device) BeforeCreate() {
 	d.UpdatedAt = time.Now()
 	d.Status = strings.ToLower(d.Status)
 	d.SecretKey = html.EscapeString(strings.TrimSpace(d.SecretKey))
+	d.Profile = html.EscapeString(strings.TrimSpace(d.Profile))
+	if d.Tags != nil {
+		d.Tags = cleanTags(d.Tags)
+	}
 
 	if d.Status != "active" && d.Status != "inactive" {
 		d.Status = "active"
@@ -67,6 +74,10 @@ func (d *Device) DeviceValidations() formaterror.GeneralError {
 	if len(d.SecretKey) > 255 {
 		errors.Errors = append(errors.Errors, "secret_key is too long")
 	}
+	if len(d.Profile) > 255 {
+		errors.Errors = append(errors.Errors, "profile is too long")
+	}
+	errors.Errors = append(errors.Errors, tagValidations(d.Tags)...)
 	return errors
 }
 
@@ -76,6 +87,10 @@ func (d *Device) PrepareUpdate() {
 	d.UpdatedAt = time.Now()
 	d.Status = strings.ToLower(d.Status)
 	d.SecretKey = html.EscapeString(strings.TrimSpace(d.SecretKey))
+	d.Profile = html.EscapeString(strings.TrimSpace(d.Profile))
+	if d.Tags != nil {
+		d.Tags = cleanTags(d.Tags)
+	}
 
 	if d.Status != "active" && d.Status != "inactive" {
 		d.Status = ""
@@ -107,6 +122,38 @@ func (d *Device) IsValidDevice(db *gorm.DB, device_id, tenant_id uuid.UUID) bool
 	return false
 }
 
+func (d *Device) ValidateDeviceCredentials(db *gorm.DB, device_id uuid.UUID, tenant_id uuid.UUID, secret_key string) error {
+
+	// check if tenant is active
+	tenant := Tenant{}
+
+	isTenantActive, errTenant := tenant.IsActive(db, tenant_id)
+	if errTenant != nil {
+		return errors.New("tenant not found")
+	}
+
+	if !isTenantActive {
+		return errors.New("tenant is inactive")
+	}
+
+	// check if device is valid
+	_, err := d.ValidateDevicePermission(db, device_id, tenant_id)
+	if err != nil {
+		return errors.New("device not found")
+	}
+
+	if secret_key != d.SecretKey {
+		return errors.New("invalid secret key")
+	}
+
+	// check if device is active
+	if d.Status != "active" {
+		return errors.New("device is inactive")
+	}
+
+	return nil
+}
+
 func (d *Device) SaveDevice(dbm *mongo.Client, db *gorm.DB, tenant_id uuid.UUID) (*Device, error) {
 	var err error
 
@@ -119,6 +166,11 @@ func (d *Device) SaveDevice(dbm *mongo.Client, db *gorm.DB, tenant_id uuid.UUID)
 		return &Device{}, err
 	}
 
+	if err = d.saveTags(db); err != nil {
+		return &Device{}, err
+	}
+	d.loadTags(db)
+
 	// create collection
 	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
 	collection := dbm.Database("siot").Collection(fmt.Sprintf("%v", d.ID))
@@ -143,9 +195,19 @@ func (d *Device) FindAllDevices(db *gorm.DB, tenant_id string, r *http.Request)
 
 	devices := []Device{}
 
+	query := db.Where("tenant_id = ?", tenant_id)
+
+	// filters
+	if r.URL.Query().Get("profile") != "" {
+		query = query.Where("profile = ?", r.URL.Query().Get("profile"))
+	}
+	if r.URL.Query().Get("tag") != "" {
+		query = query.Where("id IN (?)", db.Table("device_tags").Select("device_id").Where("tag = ?", r.URL.Query().Get("tag")).QueryExpr())
+	}
+
 	var count int
 
-	var err_count error = db.Where("tenant_id = ?", tenant_id).Find(&devices).Count(&count).Error
+	var err_count error = query.Find(&devices).Count(&count).Error
 	if err_count != nil {
 		return nil, err_count
 	}
@@ -157,11 +219,13 @@ func (d *Device) FindAllDevices(db *gorm.DB, tenant_id string, r *http.Request)
 	}
 
 	// query
-	var err error = db.Where("tenant_id = ?", tenant_id).Preload("Sensors").Limit(limit).Offset(offset).Order("updated_at desc").Find(&devices).Error
+	var err error = query.Preload("Sensors").Limit(limit).Offset(offset).Order("updated_at desc").Find(&devices).Error
 	if err != nil {
 		return nil, err
 	}
 
+	loadTags(db, devices)
+
 	return pagination.ListPaginationSerializer(limit, page, count, totalPages, nextPage, previousPage, devices), nil
 }
 
@@ -171,6 +235,8 @@ func (d *Device) FindDevice(db *gorm.DB, device_id uuid.UUID) (*Device, error) {
 	if err != nil {
 		return &Device{}, err
 	}
+
+	d.loadTags(db)
 	return d, nil
 }
 
@@ -182,11 +248,23 @@ func (d *Device) UpdateDevice(db *gorm.DB, device_id uuid.UUID) (*Device, error)
 		return &Device{}, err
 	}
 
+	// tags are only replaced when sent
+	tags := d.Tags
+
 	// get the updated device
 	var err_get_device error = db.Model(&Device{}).Where("id = ?", device_id).Preload("Sensors").Take(&d).Error
 	if err_get_device != nil {
 		return &Device{}, err_get_device
 	}
+
+	if tags != nil {
+		d.Tags = tags
+		if err := d.saveTags(db); err != nil {
+			return &Device{}, err
+		}
+	}
+
+	d.loadTags(db)
 	return d, nil
 }
 
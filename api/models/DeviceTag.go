@@ -0,0 +1,92 @@
+package models
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// DeviceTag groups devices, rules can target every device with a tag
+type DeviceTag struct {
+	DeviceID uuid.UUID `gorm:"primary_key" sql:"type:uuid REFERENCES devices(id) ON DELETE CASCADE" json:"device_id"`
+	Tag      string    `gorm:"primary_key;size:255" json:"tag"`
+}
+
+func cleanTags(tags []string) []string {
+
+	cleaned := []string{}
+	seen := map[string]bool{}
+
+	for _, tag := range tags {
+		tag = html.EscapeString(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			cleaned = append(cleaned, tag)
+		}
+	}
+	return cleaned
+}
+
+func tagValidations(tags []string) []string {
+
+	var errs []string
+
+	for i, tag := range tags {
+		if len(tag) > 255 {
+			errs = append(errs, fmt.Sprintf("tags.%v is too long", i))
+		}
+	}
+	return errs
+}
+
+// saveTags replaces the tags of the device
+func (d *Device) saveTags(db *gorm.DB) error {
+
+	var err error = db.Where("device_id = ?", d.ID).Delete(&DeviceTag{}).Error
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range d.Tags {
+		err = db.Create(&DeviceTag{DeviceID: d.ID, Tag: tag}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTags sets the tags of the devices
+func loadTags(db *gorm.DB, devices []Device) {
+
+	if len(devices) == 0 {
+		return
+	}
+
+	var device_ids []uuid.UUID
+	for i := 0; i < len(devices); i++ {
+		device_ids = append(device_ids, devices[i].ID)
+		devices[i].Tags = []string{}
+	}
+
+	deviceTags := []DeviceTag{}
+	db.Where("device_id IN (?)", device_ids).Order("tag asc").Find(&deviceTags)
+
+	for _, dt := range deviceTags {
+		for i := 0; i < len(devices); i++ {
+			if devices[i].ID == dt.DeviceID {
+				devices[i].Tags = append(devices[i].Tags, dt.Tag)
+			}
+		}
+	}
+}
+
+func (d *Device) loadTags(db *gorm.DB) {
+
+	devices := []Device{*d}
+	loadTags(db, devices)
+	d.Tags = devices[0].Tags
+}
@@ -0,0 +1,310 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"siot/api/utils/formaterror"
+	"siot/api/utils/pagination"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// EscalationPolicy notifies its steps one after the other while a firing alert is
+// not acknowledged, e.g. channel A at once, channel B after 10m and C after 30m
+type EscalationPolicy struct {
+	ID        uuid.UUID        `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	Name      string           `gorm:"size:255;not null;" json:"name"`
+	Steps     []EscalationStep `gorm:"-" json:"steps"`
+	TenantID  uuid.UUID        `sql:"type:uuid REFERENCES tenants(id) ON DELETE CASCADE" json:"-"`
+	CreatedAt time.Time        `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time        `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// EscalationStep notifies the channel when the alert has been firing for After
+type EscalationStep struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"-"`
+	Position  int       `json:"-"`
+	After     string    `gorm:"size:255;" json:"after"`
+	ChannelID uuid.UUID `sql:"type:uuid REFERENCES notification_channels(id) ON DELETE CASCADE" json:"channel_id"`
+	PolicyID  uuid.UUID `sql:"type:uuid REFERENCES escalation_policies(id) ON DELETE CASCADE" json:"-"`
+}
+
+func (p *EscalationPolicy) BeforeCreate() {
+
+	p.Name = html.EscapeString(strings.TrimSpace(p.Name))
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+}
+
+func (p *EscalationPolicy) PrepareUpdate() {
+
+	p.Name = html.EscapeString(strings.TrimSpace(p.Name))
+	p.UpdatedAt = time.Now()
+}
+
+func (p *EscalationPolicy) EscalationPolicyValidations(db *gorm.DB, tenant_id uuid.UUID) formaterror.GeneralError {
+
+	var errors formaterror.GeneralError
+
+	if p.Name == "" {
+		errors.Errors = append(errors.Errors, "name is required")
+	}
+	if len(p.Name) > 255 {
+		errors.Errors = append(errors.Errors, "name is too long")
+	}
+	if len(p.Steps) < 1 {
+		errors.Errors = append(errors.Errors, "steps is required")
+	}
+
+	var channel NotificationChannel
+	var previous time.Duration
+
+	for i, step := range p.Steps {
+		after, err := durationFromString(strings.TrimSpace(step.After))
+		if step.After == "" {
+			after, err = 0, nil
+		}
+
+		if err != nil {
+			errors.Errors = append(errors.Errors, fmt.Sprintf("invalid steps.%v.after", i))
+		} else if i > 0 && after < previous {
+			errors.Errors = append(errors.Errors, fmt.Sprintf("steps.%v.after must not be before the previous step", i))
+		}
+		previous = after
+
+		if isValid, _ := channel.IsValidNotificationChannel(db, tenant_id, step.ChannelID); !isValid {
+			errors.Errors = append(errors.Errors, fmt.Sprintf("invalid steps.%v.channel_id", i))
+		}
+	}
+
+	return errors
+}
+
+// saveSteps replaces the steps of the policy
+func (p *EscalationPolicy) saveSteps(db *gorm.DB) error {
+
+	var err error = db.Where("policy_id = ?", p.ID).Delete(&EscalationStep{}).Error
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(p.Steps); i++ {
+		step := EscalationStep{
+			Position:  i,
+			After:     strings.TrimSpace(p.Steps[i].After),
+			ChannelID: p.Steps[i].ChannelID,
+			PolicyID:  p.ID,
+		}
+
+		err = db.Create(&step).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *EscalationPolicy) loadSteps(db *gorm.DB) {
+
+	p.Steps = []EscalationStep{}
+	db.Where("policy_id = ?", p.ID).Order("position asc").Find(&p.Steps)
+}
+
+func (p *EscalationPolicy) SaveEscalationPolicy(db *gorm.DB, tenant_id uuid.UUID) (*EscalationPolicy, error) {
+
+	p.TenantID = tenant_id
+
+	// create policy
+	err := db.Model(&EscalationPolicy{}).Create(&p).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.saveSteps(db); err != nil {
+		return nil, err
+	}
+
+	p.loadSteps(db)
+	return p, nil
+}
+
+func (p *EscalationPolicy) IsValidEscalationPolicy(db *gorm.DB, tenant_id uuid.UUID, policy_id uuid.UUID) (bool, error) {
+
+	policies := []EscalationPolicy{}
+
+	// query
+	err := db.Where("tenant_id = ? AND id = ?", tenant_id, policy_id).Find(&policies).Error
+	if err != nil {
+		return false, err
+	}
+
+	if len(policies) > 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (p *EscalationPolicy) FindAllEscalationPolicies(db *gorm.DB, tenant_id string, r *http.Request) (interface{}, error) {
+
+	policies := []EscalationPolicy{}
+
+	var count int
+
+	var err_count error = db.Where("tenant_id = ?", tenant_id).Find(&policies).Count(&count).Error
+	if err_count != nil {
+		return nil, err_count
+	}
+
+	// pagination
+	offset, limit, page, totalPages, nextPage, previousPage, errPagination := pagination.ValidatePagination(r, count)
+	if errPagination != nil {
+		return nil, errPagination
+	}
+
+	// query
+	var err error = db.Where("tenant_id = ?", tenant_id).Limit(limit).Offset(offset).Order("updated_at desc").Find(&policies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(policies); i++ {
+		policies[i].loadSteps(db)
+	}
+
+	return pagination.ListPaginationSerializer(limit, page, count, totalPages, nextPage, previousPage, policies), nil
+}
+
+func (p *EscalationPolicy) GetEscalationPolicy(db *gorm.DB, policy_id string) (*EscalationPolicy, error) {
+
+	policy := EscalationPolicy{}
+
+	// query
+	err := db.Model(&EscalationPolicy{}).Where("id = ?", policy_id).Take(&policy).Error
+	if err != nil {
+		return nil, err
+	}
+
+	policy.loadSteps(db)
+	return &policy, nil
+}
+
+func (p *EscalationPolicy) UpdateEscalationPolicy(db *gorm.DB, policy_id string) (*EscalationPolicy, error) {
+
+	steps := p.Steps
+
+	var err error = db.Model(&EscalationPolicy{}).Where("id = ?", policy_id).Updates(&p).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// get the updated policy
+	var err_get error = db.Model(&EscalationPolicy{}).Where("id = ?", policy_id).Take(&p).Error
+	if err_get != nil {
+		return nil, err_get
+	}
+
+	p.Steps = steps
+	if err := p.saveSteps(db); err != nil {
+		return nil, err
+	}
+
+	p.loadSteps(db)
+	return p, nil
+}
+
+func (p *EscalationPolicy) DeleteEscalationPolicy(db *gorm.DB, policy_id string) error {
+
+	var err error = db.Where("id = ?", policy_id).Delete(&EscalationPolicy{}).Error
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// escalate notifies the steps of the escalation policy of the rule that are due
+// for the firing alert. The escalation level of the alert is the number of steps
+// already notified.
+func (r *Rule) escalate(db *gorm.DB, alert *Alert) error {
+
+	if r.EscalationPolicyID == nil || alert.State != AlertStateFiring || alert.AcknowledgedAt != nil || alert.FiredAt == nil {
+		return nil
+	}
+
+	// escalation waits until the maintenance ends
+	if activeMaintenanceWindow(db, r.TenantID, alert.DeviceID) != nil {
+		return nil
+	}
+
+	policy := EscalationPolicy{ID: *r.EscalationPolicyID}
+	policy.loadSteps(db)
+
+	lastData := map[string]interface{}{"collected_at": alert.UpdatedAt.UTC().Format("2006-01-02T15:04:05.000Z")}
+
+	for alert.EscalationLevel < len(policy.Steps) {
+		level := alert.EscalationLevel
+		step := policy.Steps[level]
+
+		after, _ := durationFromString(step.After)
+		if time.Since(*alert.FiredAt) < after {
+			break
+		}
+
+		// the step is claimed before it is notified, an evaluation running at
+		// the same time or an acknowledgement in between stops the escalation
+		claim := db.Model(&Alert{}).Where("id = ? AND escalation_level = ? AND acknowledged_at IS NULL", alert.ID, level).UpdateColumn("escalation_level", level+1)
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected != 1 {
+			return nil
+		}
+		alert.EscalationLevel = level + 1
+
+		channel := NotificationChannel{}
+		if db.Where("id = ?", step.ChannelID).Take(&channel).Error == nil {
+			r.queueChannelNotification(db, alert, AlertStateFiring, &channel, fmt.Sprintf("%v (escalation step %v)", channel.Name, level+1), r.newNotification(alert, lastData, AlertStateFiring))
+		}
+	}
+
+	return nil
+}
+
+// AcknowledgeAlert stops the escalation of a firing alert
+func (a *Alert) AcknowledgeAlert(db *gorm.DB, alert_id string, user_id uuid.UUID) (*Alert, error) {
+
+	var err error = db.Where("id = ?", alert_id).Take(&a).Error
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	// the alert is acknowledged once, and not after it was resolved
+	update := db.Model(&Alert{}).Where("id = ? AND acknowledged_at IS NULL AND state <> ?", alert_id, AlertStateResolved).Updates(map[string]interface{}{
+		"acknowledged_at": now,
+		"acknowledged_by": user_id,
+		"updated_at":      now,
+	})
+	if update.Error != nil {
+		return nil, update.Error
+	}
+	if update.RowsAffected != 1 {
+		db.Where("id = ?", alert_id).Take(&a)
+		if a.State == AlertStateResolved {
+			return nil, errors.New("resolved alerts can not be acknowledged")
+		}
+		return nil, errors.New("alert is already acknowledged")
+	}
+
+	a.AcknowledgedAt = &now
+	a.AcknowledgedBy = &user_id
+	a.UpdatedAt = now
+	return a, nil
+}
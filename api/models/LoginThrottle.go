@@ -0,0 +1,307 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"siot/api/utils/email"
+
+	"github.com/jinzhu/gorm"
+)
+
+// LoginThrottle counts the login attempts of an email or an ip address, the key
+// is email:<email> or ip:<address>. The attempts are counted before the password
+// is checked and forgotten on success. It is stored so restarts do not reset it.
+type LoginThrottle struct {
+	Key           string     `gorm:"primary_key;size:255" json:"key"`
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+// loginMaxAttempts is LOGIN_MAX_ATTEMPTS, the failures of an email before it is
+// locked, default 5
+func loginMaxAttempts() int {
+	return envInt("LOGIN_MAX_ATTEMPTS", 5)
+}
+
+// loginIPMaxAttempts is LOGIN_IP_MAX_ATTEMPTS, the failures of an ip address
+// before it is locked, default 50
+func loginIPMaxAttempts() int {
+	return envInt("LOGIN_IP_MAX_ATTEMPTS", 50)
+}
+
+// loginLockout is LOGIN_LOCKOUT, the duration of the lockout and the window the
+// failures are counted in, default 15m
+func loginLockout() time.Duration {
+	return envDuration("LOGIN_LOCKOUT", 15*time.Minute)
+}
+
+// loginDelay is LOGIN_DELAY, the wait after the first failure of an email. It
+// doubles on every failure, default 1s
+func loginDelay() time.Duration {
+	return envDuration("LOGIN_DELAY", time.Second)
+}
+
+func emailThrottleKey(userEmail string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(userEmail))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// progressiveDelay is the wait after the failures of an email, at most the lockout
+func progressiveDelay(failures int) time.Duration {
+
+	if failures < 1 {
+		return 0
+	}
+
+	delay := float64(loginDelay()) * math.Pow(2, float64(failures-1))
+	if delay > float64(loginLockout()) {
+		return loginLockout()
+	}
+	return time.Duration(delay)
+}
+
+// LoginLockedError is returned while the email or the ip address must wait
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("too many attempts, try again in %v", e.RetryAfter.Round(time.Second))
+}
+
+// throttleLimit is the maximum attempts of a key in the window, the key is then
+// locked for the window. Slow keys also wait the progressive delay between two
+// attempts.
+type throttleLimit struct {
+	Key    string
+	Max    int
+	Window time.Duration
+	Slow   bool
+}
+
+// LoginAttempt is a login counted before the password is checked
+type LoginAttempt struct {
+	Email  string
+	IP     string
+	locked []string
+}
+
+// ReserveLogin counts the attempt of the email and the ip address before the
+// password is checked, so parallel attempts can not pass the delay or the
+// lockout. It returns a LoginLockedError when the attempt must wait.
+func ReserveLogin(db *gorm.DB, userEmail string, ip string) (*LoginAttempt, error) {
+
+	limits := []throttleLimit{}
+	if userEmail != "" {
+		limits = append(limits, throttleLimit{Key: emailThrottleKey(userEmail), Max: loginMaxAttempts(), Window: loginLockout(), Slow: true})
+	}
+	if ip != "" {
+		limits = append(limits, throttleLimit{Key: ipThrottleKey(ip), Max: loginIPMaxAttempts(), Window: loginLockout()})
+	}
+
+	locked, err := reserveThrottles(db, limits)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginAttempt{Email: userEmail, IP: ip, locked: locked}, nil
+}
+
+// Failed notifies the lockouts started by the attempt, the failure is already
+// counted
+func (a *LoginAttempt) Failed(db *gorm.DB) {
+
+	for _, key := range a.locked {
+		if key == emailThrottleKey(a.Email) {
+			notifyLockout(db, a.Email)
+		} else {
+			log.Printf("login: the address %v is locked after %v failed attempts", a.IP, loginIPMaxAttempts())
+		}
+	}
+}
+
+// Succeeded forgets the attempts of the email and gives the attempt back to the
+// ip address, only the failures of an address are counted
+func (a *LoginAttempt) Succeeded(db *gorm.DB) {
+
+	RecordLoginSuccess(db, a.Email)
+	a.release(db)
+}
+
+// PasswordVerified gives the attempt back to the ip address when the second
+// factor is still missing. The attempts of the email hold the failed codes and
+// are forgotten once the second factor is verified.
+func (a *LoginAttempt) PasswordVerified(db *gorm.DB) {
+	a.release(db)
+}
+
+// release gives the attempt back to the ip address
+func (a *LoginAttempt) release(db *gorm.DB) {
+
+	if a.IP == "" {
+		return
+	}
+
+	key := ipThrottleKey(a.IP)
+	for _, locked := range a.locked {
+		if locked == key {
+			db.Exec("UPDATE login_throttles SET failures = GREATEST(failures - 1, 0), locked_until = NULL WHERE key = ?", key)
+			return
+		}
+	}
+	db.Exec("UPDATE login_throttles SET failures = GREATEST(failures - 1, 0) WHERE key = ?", key)
+}
+
+// reserveThrottles counts an attempt of every key in one transaction. The rows
+// are locked so concurrent attempts of a key are counted one after the other.
+// Nothing is counted when a key must wait. It returns the keys locked by this
+// attempt.
+func reserveThrottles(db *gorm.DB, limits []throttleLimit) ([]string, error) {
+
+	// the rows are always locked in the same order
+	sort.Slice(limits, func(i, j int) bool { return limits[i].Key < limits[j].Key })
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	var locked []string
+	var wait time.Duration
+
+	for _, limit := range limits {
+		retryAfter, lockedNow, err := reserveThrottle(tx, limit)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if lockedNow {
+			locked = append(locked, limit.Key)
+		}
+	}
+
+	if wait > 0 {
+		tx.Rollback()
+		return nil, &LoginLockedError{RetryAfter: wait}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return locked, nil
+}
+
+// reserveThrottle counts an attempt of the key inside the transaction. It returns
+// how long the key must wait, or if this attempt reached the maximum and locked
+// the key.
+func reserveThrottle(tx *gorm.DB, limit throttleLimit) (time.Duration, bool, error) {
+
+	now := time.Now()
+
+	var err error = tx.Exec("INSERT INTO login_throttles (key, failures, last_failure_at) VALUES (?, 0, ?) ON CONFLICT (key) DO NOTHING", limit.Key, time.Time{}).Error
+	if err != nil {
+		return 0, false, err
+	}
+
+	throttle := LoginThrottle{}
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("key = ?", limit.Key).Take(&throttle).Error; err != nil {
+		return 0, false, err
+	}
+
+	if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+		return throttle.LockedUntil.Sub(now), false, nil
+	}
+
+	// the attempts start again after the lockout or the window
+	if throttle.LockedUntil != nil || throttle.LastFailureAt.Before(now.Add(-limit.Window)) {
+		throttle.Failures = 0
+		throttle.LockedUntil = nil
+	}
+
+	// only the emails are slowed down, many users can share an address
+	if limit.Slow {
+		if retry := throttle.LastFailureAt.Add(progressiveDelay(throttle.Failures)); retry.After(now) {
+			return retry.Sub(now), false, nil
+		}
+	}
+
+	throttle.Failures++
+	throttle.LastFailureAt = now
+	if throttle.Failures >= limit.Max {
+		lockedUntil := now.Add(limit.Window)
+		throttle.LockedUntil = &lockedUntil
+	}
+
+	var errUpdate error = tx.Model(&LoginThrottle{}).Where("key = ?", limit.Key).Updates(map[string]interface{}{
+		"failures":        throttle.Failures,
+		"last_failure_at": throttle.LastFailureAt,
+		"locked_until":    throttle.LockedUntil,
+	}).Error
+	if errUpdate != nil {
+		return 0, false, errUpdate
+	}
+
+	return 0, throttle.LockedUntil != nil, nil
+}
+
+// CheckLockout returns a LoginLockedError while the email or the ip address is
+// locked. It is used by the logins that do not check a password.
+func CheckLockout(db *gorm.DB, userEmail string, ip string) error {
+
+	now := time.Now()
+
+	throttles := []LoginThrottle{}
+	db.Where("key IN (?) AND locked_until > ?", []string{emailThrottleKey(userEmail), ipThrottleKey(ip)}, now).Find(&throttles)
+
+	var wait time.Duration
+	for _, throttle := range throttles {
+		if d := throttle.LockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+
+	if wait > 0 {
+		return &LoginLockedError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// notifyLockout emails the user of a locked email, unknown emails are ignored
+func notifyLockout(db *gorm.DB, userEmail string) {
+
+	user := User{}
+	if db.Where("lower(email) = lower(?)", strings.TrimSpace(userEmail)).Take(&user).Error != nil {
+		return
+	}
+
+	lockedFor := formatDuration(loginLockout())
+	go email.SendAccountLockedEmail([]string{user.Email}, "[SIOT] Your account was locked", user.FirstName, user.LastName, lockedFor, fmt.Sprintf("%d", loginMaxAttempts()))
+}
+
+// RecordLoginSuccess forgets the attempts of the email
+func RecordLoginSuccess(db *gorm.DB, userEmail string) {
+
+	db.Where("key = ?", emailThrottleKey(userEmail)).Delete(&LoginThrottle{})
+}
+
+// UnlockUser removes the lockout and the failed attempts of the user
+func (u *User) UnlockUser(db *gorm.DB, user_id string) error {
+
+	var err error = db.Model(&User{}).Where("id = ?", user_id).Take(&u).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Where("key = ?", emailThrottleKey(u.Email)).Delete(&LoginThrottle{}).Error
+}
@@ -0,0 +1,258 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"siot/api/auth"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// number of recovery codes generated on enrollment
+const recoveryCodesCount = 10
+
+// RecoveryCode replaces a TOTP code once, e.g. when the phone is lost. Only its
+// hash is stored.
+type RecoveryCode struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	CodeHash  string     `gorm:"size:255;not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	UserID    uuid.UUID  `sql:"type:uuid REFERENCES users(id) ON DELETE CASCADE" json:"-"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func (c *RecoveryCode) BeforeCreate() {
+
+	c.CreatedAt = time.Now()
+}
+
+// MFAEnrollment is returned once when the user starts the enrollment, the secret
+// and the recovery codes can not be read again
+type MFAEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"otpauth_uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// normalizeRecoveryCode lets the users type the codes with or without the dash
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+}
+
+// EnrollMFA generates a new secret and recovery codes for the user. Two-factor
+// authentication is enabled once a code of the secret is verified.
+func (u *User) EnrollMFA(db *gorm.DB, user_id string) (*MFAEnrollment, error) {
+
+	var err error = db.Model(&User{}).Where("id = ?", user_id).Take(&u).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if u.MFAEnabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	var errUpdate error = db.Model(&User{}).Where("id = ?", u.ID).Updates(map[string]interface{}{
+		"mfa_secret":    secret,
+		"mfa_last_step": 0,
+		"updated_at":    time.Now(),
+	}).Error
+	if errUpdate != nil {
+		return nil, errUpdate
+	}
+
+	codes, err := u.generateRecoveryCodes(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{
+		Secret:        secret,
+		URI:           auth.TOTPURI(secret, u.Email),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// generateRecoveryCodes replaces the recovery codes of the user
+func (u *User) generateRecoveryCodes(db *gorm.DB) ([]string, error) {
+
+	var err error = db.Where("user_id = ?", u.ID).Delete(&RecoveryCode{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	codes := []string{}
+	for i := 0; i < recoveryCodesCount; i++ {
+		code := randStr(5)
+		err = db.Create(&RecoveryCode{CodeHash: hashApiKey(code), UserID: u.ID}).Error
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
+
+// EnableMFA confirms the enrollment with a code of the new secret. The sessions
+// opened with the password only are closed.
+func (u *User) EnableMFA(db *gorm.DB, user_id string, code string) error {
+
+	var err error = db.Model(&User{}).Where("id = ?", user_id).Take(&u).Error
+	if err != nil {
+		return err
+	}
+
+	if u.MFAEnabled {
+		return errors.New("two-factor authentication is already enabled")
+	}
+	if u.MFASecret == "" {
+		return errors.New("two-factor authentication is not enrolled")
+	}
+
+	if !u.useTOTPCode(db, code) {
+		return errors.New("invalid code")
+	}
+
+	var errUpdate error = db.Model(&User{}).Where("id = ?", u.ID).Updates(map[string]interface{}{
+		"mfa_enabled": true,
+		"updated_at":  time.Now(),
+	}).Error
+	if errUpdate != nil {
+		return errUpdate
+	}
+
+	return RevokeUserTokens(db, u.ID)
+}
+
+// DisableMFA removes the second factor, a valid code is required
+func (u *User) DisableMFA(db *gorm.DB, user_id string, code string) error {
+
+	var err error = db.Model(&User{}).Where("id = ?", user_id).Take(&u).Error
+	if err != nil {
+		return err
+	}
+
+	if !u.MFAEnabled {
+		return errors.New("two-factor authentication is not enabled")
+	}
+
+	if err := u.VerifyMFA(db, code); err != nil {
+		return err
+	}
+
+	var errUpdate error = db.Model(&User{}).Where("id = ?", u.ID).Updates(map[string]interface{}{
+		"mfa_enabled":   false,
+		"mfa_secret":    "",
+		"mfa_last_step": 0,
+		"updated_at":    time.Now(),
+	}).Error
+	if errUpdate != nil {
+		return errUpdate
+	}
+
+	return db.Where("user_id = ?", u.ID).Delete(&RecoveryCode{}).Error
+}
+
+// VerifyMFA accepts a TOTP code or an unused recovery code of the user
+func (u *User) VerifyMFA(db *gorm.DB, code string) error {
+
+	if u.useTOTPCode(db, code) || u.useRecoveryCode(db, code) {
+		return nil
+	}
+	return errors.New("invalid code")
+}
+
+// useTOTPCode checks the code and stores its step, a code can not be used twice
+func (u *User) useTOTPCode(db *gorm.DB, code string) bool {
+
+	step, ok := auth.ValidateTOTP(u.MFASecret, code, time.Now())
+	if !ok {
+		return false
+	}
+
+	result := db.Model(&User{}).Where("id = ? AND mfa_last_step < ?", u.ID, step).UpdateColumn("mfa_last_step", step)
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+func (u *User) useRecoveryCode(db *gorm.DB, code string) bool {
+
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false
+	}
+
+	result := db.Model(&RecoveryCode{}).Where("user_id = ? AND code_hash = ? AND used_at IS NULL", u.ID, hashApiKey(code)).UpdateColumn("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+// CompleteMFALogin exchanges the challenge token of the login and a code for
+// the access and refresh tokens
+func CompleteMFALogin(db *gorm.DB, mfa_token string, code string, ip string) (*User, *TokenPair, error) {
+
+	user_id, err := auth.ParseMFAToken(mfa_token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user := User{}
+	if db.Where("id = ?", user_id).Take(&user).Error != nil {
+		return nil, nil, errors.New("user not found")
+	}
+	if user.Status == "inactive" {
+		return nil, nil, errors.New("user is inactive")
+	}
+	if !user.MFAEnabled {
+		return nil, nil, errors.New("two-factor authentication is not enabled")
+	}
+
+	// the codes are throttled like the passwords
+	attempt, err := ReserveLogin(db, user.Email, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := user.VerifyMFA(db, code); err != nil {
+		attempt.Failed(db)
+		return nil, nil, err
+	}
+	attempt.Succeeded(db)
+
+	tokens, err := IssueTokens(db, &user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &user, tokens, nil
+}
+
+// TenantRequiresMFA reports if the members of the tenant must use two-factor
+// authentication
+func TenantRequiresMFA(db *gorm.DB, tenant_id uuid.UUID) bool {
+
+	tenant := Tenant{}
+	if db.Select("require_mfa").Where("id = ?", tenant_id).Take(&tenant).Error != nil {
+		return false
+	}
+	return tenant.RequireMFA != nil && *tenant.RequireMFA
+}
+
+// MissingMFA reports if the tenant requires two-factor authentication and the
+// user has not enabled it
+func MissingMFA(db *gorm.DB, user_id uuid.UUID, tenant_id uuid.UUID) bool {
+
+	if !TenantRequiresMFA(db, tenant_id) {
+		return false
+	}
+
+	user := User{}
+	if db.Select("mfa_enabled").Where("id = ?", user_id).Take(&user).Error != nil {
+		return true
+	}
+	return !user.MFAEnabled
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"html"
+	"net/http"
+	"siot/api/utils/formaterror"
+	"siot/api/utils/pagination"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// MaintenanceWindow silences the notifications of the rules of a device, or of
+// every device of the tenant when DeviceID is empty. The rules are still evaluated
+// and the suppressed notifications are recorded as rule events.
+type MaintenanceWindow struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	Name      string     `gorm:"size:255;not null;" json:"name"`
+	StartsAt  time.Time  `json:"starts_at"`
+	EndsAt    time.Time  `json:"ends_at"`
+	DeviceID  *uuid.UUID `sql:"type:uuid REFERENCES devices(id) ON DELETE CASCADE" json:"device_id"`
+	TenantID  uuid.UUID  `sql:"type:uuid REFERENCES tenants(id) ON DELETE CASCADE" json:"-"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (m *MaintenanceWindow) BeforeCreate() {
+
+	m.Name = html.EscapeString(strings.TrimSpace(m.Name))
+	m.CreatedAt = time.Now()
+	m.UpdatedAt = time.Now()
+}
+
+func (m *MaintenanceWindow) MaintenanceWindowValidations(db *gorm.DB, tenant_id uuid.UUID) formaterror.GeneralError {
+
+	var errors formaterror.GeneralError
+
+	if m.Name == "" {
+		errors.Errors = append(errors.Errors, "name is required")
+	}
+	if len(m.Name) > 255 {
+		errors.Errors = append(errors.Errors, "name is too long")
+	}
+	if m.StartsAt.IsZero() {
+		errors.Errors = append(errors.Errors, "starts_at is required")
+	}
+	if m.EndsAt.IsZero() {
+		errors.Errors = append(errors.Errors, "ends_at is required")
+	} else if !m.EndsAt.After(m.StartsAt) {
+		errors.Errors = append(errors.Errors, "ends_at must be after starts_at")
+	} else if m.EndsAt.Before(time.Now()) {
+		errors.Errors = append(errors.Errors, "ends_at is in the past")
+	}
+
+	// validate device id
+	var device Device
+	if m.DeviceID != nil && !device.IsValidDevice(db, *m.DeviceID, tenant_id) {
+		errors.Errors = append(errors.Errors, "invalid device_id")
+	}
+
+	return errors
+}
+
+func (m *MaintenanceWindow) SaveMaintenanceWindow(db *gorm.DB, tenant_id uuid.UUID) (*MaintenanceWindow, error) {
+
+	m.TenantID = tenant_id
+
+	// create window
+	err := db.Model(&MaintenanceWindow{}).Create(&m).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *MaintenanceWindow) IsValidMaintenanceWindow(db *gorm.DB, tenant_id uuid.UUID, window_id uuid.UUID) (bool, error) {
+
+	windows := []MaintenanceWindow{}
+
+	// query
+	err := db.Where("tenant_id = ? AND id = ?", tenant_id, window_id).Find(&windows).Error
+	if err != nil {
+		return false, err
+	}
+
+	if len(windows) > 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (m *MaintenanceWindow) FindAllMaintenanceWindows(db *gorm.DB, tenant_id string, r *http.Request) (interface{}, error) {
+
+	windows := []MaintenanceWindow{}
+
+	query := db.Where("tenant_id = ?", tenant_id)
+
+	// filters
+	if r.URL.Query().Get("device_id") != "" {
+		query = query.Where("device_id = ?", r.URL.Query().Get("device_id"))
+	}
+	if r.URL.Query().Get("active") == "true" {
+		now := time.Now()
+		query = query.Where("starts_at <= ? AND ends_at > ?", now, now)
+	}
+
+	var count int
+
+	var err_count error = query.Find(&windows).Count(&count).Error
+	if err_count != nil {
+		return nil, err_count
+	}
+
+	// pagination
+	offset, limit, page, totalPages, nextPage, previousPage, errPagination := pagination.ValidatePagination(r, count)
+	if errPagination != nil {
+		return nil, errPagination
+	}
+
+	// query
+	var err error = query.Limit(limit).Offset(offset).Order("starts_at desc").Find(&windows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return pagination.ListPaginationSerializer(limit, page, count, totalPages, nextPage, previousPage, windows), nil
+}
+
+func (m *MaintenanceWindow) DeleteMaintenanceWindow(db *gorm.DB, window_id string) error {
+
+	var err error = db.Where("id = ?", window_id).Delete(&MaintenanceWindow{}).Error
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// activeMaintenanceWindow returns the window that silences the device now, if any
+func activeMaintenanceWindow(db *gorm.DB, tenant_id uuid.UUID, device_id uuid.UUID) *MaintenanceWindow {
+
+	windows := []MaintenanceWindow{}
+	now := time.Now()
+
+	db.Where("tenant_id = ? AND (device_id IS NULL OR device_id = ?) AND starts_at <= ? AND ends_at > ?", tenant_id, device_id, now, now).
+		Order("ends_at desc").Limit(1).Find(&windows)
+
+	if len(windows) > 0 {
+		return &windows[0]
+	}
+	return nil
+}
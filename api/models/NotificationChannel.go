@@ -0,0 +1,316 @@
+package models
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"siot/api/utils/formaterror"
+	"siot/api/utils/pagination"
+	"strings"
+	"time"
+
+	"github.com/badoux/checkmail"
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// types of notification channels
+const (
+	ChannelTypeEmail    = "email"
+	ChannelTypeWebhook  = "webhook"
+	ChannelTypeSlack    = "slack"
+	ChannelTypeTeams    = "teams"
+	ChannelTypeTelegram = "telegram"
+)
+
+// NotificationChannel is a destination of the rule notifications of a tenant. The
+// secrets (webhook urls, bot tokens, signing secrets) are only returned on creation.
+type NotificationChannel struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	Name      string    `gorm:"size:255;not null;" json:"name"`
+	Type      string    `gorm:"size:255;not null;" json:"type"`
+	Config    JSONB     `sql:"type:jsonb" json:"config"`
+	Secrets   JSONB     `sql:"type:jsonb" json:"secrets,omitempty"`
+	Status    string    `gorm:"size:255;" json:"status"`
+	TenantID  uuid.UUID `sql:"type:uuid REFERENCES tenants(id) ON DELETE CASCADE" json:"-"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// RuleChannel links a rule with the channels it notifies
+type RuleChannel struct {
+	RuleID    uuid.UUID `gorm:"primary_key" sql:"type:uuid REFERENCES rules(id) ON DELETE CASCADE" json:"rule_id"`
+	ChannelID uuid.UUID `gorm:"primary_key" sql:"type:uuid REFERENCES notification_channels(id) ON DELETE CASCADE" json:"channel_id"`
+}
+
+func (c *NotificationChannel) BeforeCreate() {
+
+	c.Name = html.EscapeString(strings.TrimSpace(c.Name))
+	c.Type = strings.ToLower(strings.TrimSpace(c.Type))
+	c.Status = strings.ToLower(c.Status)
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+
+	if c.Status != "active" && c.Status != "inactive" {
+		c.Status = "active"
+	}
+
+	// generic webhooks are signed like the rule webhooks
+	if c.Type == ChannelTypeWebhook {
+		if c.Secrets == nil {
+			c.Secrets = JSONB{}
+		}
+		if c.Secrets["signing_secret"] == nil || c.Secrets["signing_secret"] == "" {
+			c.Secrets["signing_secret"] = randStr(32)
+		}
+	}
+}
+
+func (c *NotificationChannel) PrepareUpdate() {
+
+	c.Name = html.EscapeString(strings.TrimSpace(c.Name))
+	c.Type = strings.ToLower(strings.TrimSpace(c.Type))
+	c.Status = strings.ToLower(c.Status)
+	c.UpdatedAt = time.Now()
+
+	if c.Status != "active" && c.Status != "inactive" {
+		c.Status = ""
+	}
+}
+
+// channelValue returns a config or secret value as a string
+func channelValue(values JSONB, key string) string {
+
+	if values == nil || values[key] == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprintf("%v", values[key]))
+}
+
+func (c *NotificationChannel) NotificationChannelValidations() formaterror.GeneralError {
+
+	var errors formaterror.GeneralError
+
+	if c.Name == "" {
+		errors.Errors = append(errors.Errors, "name is required")
+	}
+	if len(c.Name) > 255 {
+		errors.Errors = append(errors.Errors, "name is too long")
+	}
+
+	switch c.Type {
+	case ChannelTypeEmail:
+		if channelValue(c.Config, "to") == "" {
+			errors.Errors = append(errors.Errors, "config.to is required")
+		} else if err := checkmail.ValidateFormat(channelValue(c.Config, "to")); err != nil {
+			errors.Errors = append(errors.Errors, "invalid config.to email")
+		}
+	case ChannelTypeWebhook:
+		if !strings.HasPrefix(channelValue(c.Config, "url"), "http") {
+			errors.Errors = append(errors.Errors, "config.url is required")
+		}
+	case ChannelTypeSlack, ChannelTypeTeams:
+		if !strings.HasPrefix(channelValue(c.Secrets, "webhook_url"), "http") {
+			errors.Errors = append(errors.Errors, "secrets.webhook_url is required")
+		}
+	case ChannelTypeTelegram:
+		if channelValue(c.Secrets, "bot_token") == "" {
+			errors.Errors = append(errors.Errors, "secrets.bot_token is required")
+		}
+		if channelValue(c.Config, "chat_id") == "" {
+			errors.Errors = append(errors.Errors, "config.chat_id is required")
+		}
+	default:
+		errors.Errors = append(errors.Errors, "invalid type. The available types are: email, webhook, slack, teams and telegram")
+	}
+
+	return errors
+}
+
+// hideSecrets removes the secrets before the channel is returned
+func (c *NotificationChannel) hideSecrets() {
+	c.Secrets = nil
+}
+
+func (c *NotificationChannel) SaveNotificationChannel(db *gorm.DB, tenant_id uuid.UUID) (*NotificationChannel, error) {
+
+	c.TenantID = tenant_id
+
+	// create channel
+	err := db.Model(&NotificationChannel{}).Create(&c).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *NotificationChannel) IsValidNotificationChannel(db *gorm.DB, tenant_id uuid.UUID, channel_id uuid.UUID) (bool, error) {
+
+	channels := []NotificationChannel{}
+
+	// query
+	err := db.Where("tenant_id = ? AND id = ?", tenant_id, channel_id).Find(&channels).Error
+	if err != nil {
+		return false, err
+	}
+
+	if len(channels) > 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (c *NotificationChannel) FindAllNotificationChannels(db *gorm.DB, tenant_id string, r *http.Request) (interface{}, error) {
+
+	channels := []NotificationChannel{}
+
+	query := db.Where("tenant_id = ?", tenant_id)
+
+	// filters
+	if r.URL.Query().Get("type") != "" {
+		query = query.Where("type = ?", r.URL.Query().Get("type"))
+	}
+
+	var count int
+
+	var err_count error = query.Find(&channels).Count(&count).Error
+	if err_count != nil {
+		return nil, err_count
+	}
+
+	// pagination
+	offset, limit, page, totalPages, nextPage, previousPage, errPagination := pagination.ValidatePagination(r, count)
+	if errPagination != nil {
+		return nil, errPagination
+	}
+
+	// query
+	var err error = query.Limit(limit).Offset(offset).Order("updated_at desc").Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(channels); i++ {
+		channels[i].hideSecrets()
+	}
+
+	return pagination.ListPaginationSerializer(limit, page, count, totalPages, nextPage, previousPage, channels), nil
+}
+
+func (c *NotificationChannel) GetNotificationChannel(db *gorm.DB, channel_id string) (*NotificationChannel, error) {
+
+	channel := NotificationChannel{}
+
+	// query
+	err := db.Model(&NotificationChannel{}).Where("id = ?", channel_id).Take(&channel).Error
+	if err != nil {
+		return nil, err
+	}
+
+	channel.hideSecrets()
+	return &channel, nil
+}
+
+// KeepSecrets sets the stored secrets when the update does not send new ones
+func (c *NotificationChannel) KeepSecrets(db *gorm.DB, channel_id string) {
+
+	if c.Secrets != nil {
+		return
+	}
+
+	channel := NotificationChannel{}
+	db.Model(&NotificationChannel{}).Where("id = ?", channel_id).Take(&channel)
+	c.Secrets = channel.Secrets
+}
+
+// UpdateNotificationChannel updates the channel, secrets are only replaced when sent
+func (c *NotificationChannel) UpdateNotificationChannel(db *gorm.DB, channel_id string) (*NotificationChannel, error) {
+
+	var err error = db.Model(&NotificationChannel{}).Where("id = ?", channel_id).Updates(&c).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	// get the updated channel
+	var err_get error = db.Model(&NotificationChannel{}).Where("id = ?", channel_id).Take(&c).Error
+	if err_get != nil {
+		return nil, err_get
+	}
+
+	c.hideSecrets()
+	return c, nil
+}
+
+func (c *NotificationChannel) DeleteNotificationChannel(db *gorm.DB, channel_id string) error {
+
+	var err error = db.Where("id = ?", channel_id).Delete(&NotificationChannel{}).Error
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ruleChannels returns the active channels of the rule
+func (r *Rule) ruleChannels(db *gorm.DB) []NotificationChannel {
+
+	channels := []NotificationChannel{}
+
+	db.Joins("JOIN rule_channels ON rule_channels.channel_id = notification_channels.id").
+		Where("rule_channels.rule_id = ? AND notification_channels.status = ?", r.ID, "active").
+		Find(&channels)
+
+	return channels
+}
+
+// saveChannels replaces the channels of the rule with ChannelIDs
+func (r *Rule) saveChannels(db *gorm.DB) error {
+
+	var err error = db.Where("rule_id = ?", r.ID).Delete(&RuleChannel{}).Error
+	if err != nil {
+		return err
+	}
+
+	for _, channel_id := range r.ChannelIDs {
+		err = db.Create(&RuleChannel{RuleID: r.ID, ChannelID: channel_id}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Rule) loadChannelIDs(db *gorm.DB) {
+
+	rules := []Rule{*r}
+	loadChannelIDs(db, rules)
+	r.ChannelIDs = rules[0].ChannelIDs
+}
+
+// loadChannelIDs sets the ChannelIDs of the rules
+func loadChannelIDs(db *gorm.DB, rules []Rule) {
+
+	if len(rules) == 0 {
+		return
+	}
+
+	var rule_ids []uuid.UUID
+	for i := 0; i < len(rules); i++ {
+		rule_ids = append(rule_ids, rules[i].ID)
+		rules[i].ChannelIDs = []uuid.UUID{}
+	}
+
+	ruleChannels := []RuleChannel{}
+	db.Where("rule_id IN (?)", rule_ids).Find(&ruleChannels)
+
+	for _, rc := range ruleChannels {
+		for i := 0; i < len(rules); i++ {
+			if rules[i].ID == rc.RuleID {
+				rules[i].ChannelIDs = append(rules[i].ChannelIDs, rc.ChannelID)
+			}
+		}
+	}
+}
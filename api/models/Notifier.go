@@ -0,0 +1,247 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// Notification is the message sent to the channels of a rule when its alert
+// changes state
+type Notification struct {
+	Title       string    `json:"title"`
+	Text        string    `json:"text"`
+	State       string    `json:"state"`
+	Sensor      string    `json:"sensor"`
+	Value       string    `json:"value"`
+	CollectedAt string    `json:"collected_at"`
+	RuleID      uuid.UUID `json:"rule_id"`
+	DeviceID    uuid.UUID `json:"device_id"`
+	AlertID     uuid.UUID `json:"alert_id"`
+}
+
+// Notifier sends notifications through a channel
+type Notifier interface {
+	Send(n *Notification) error
+}
+
+// Notifier returns the implementation of the channel type
+func (c *NotificationChannel) Notifier(client *http.Client) (Notifier, error) {
+
+	switch c.Type {
+	case ChannelTypeEmail:
+		return &EmailNotifier{To: channelValue(c.Config, "to")}, nil
+	case ChannelTypeWebhook:
+		headers := map[string]string{}
+		if h, ok := c.Config["headers"].(map[string]interface{}); ok {
+			for key, value := range h {
+				headers[key] = fmt.Sprintf("%v", value)
+			}
+		}
+		return &WebhookNotifier{Client: client, Url: channelValue(c.Config, "url"), Headers: headers, SigningSecret: channelValue(c.Secrets, "signing_secret")}, nil
+	case ChannelTypeSlack:
+		return &SlackNotifier{Client: client, WebhookUrl: channelValue(c.Secrets, "webhook_url")}, nil
+	case ChannelTypeTeams:
+		return &TeamsNotifier{Client: client, WebhookUrl: channelValue(c.Secrets, "webhook_url")}, nil
+	case ChannelTypeTelegram:
+		return &TelegramNotifier{Client: client, ApiUrl: channelValue(c.Config, "api_url"), BotToken: channelValue(c.Secrets, "bot_token"), ChatID: channelValue(c.Config, "chat_id")}, nil
+	}
+
+	return nil, errors.New("invalid channel type")
+}
+
+// newNotification builds the notification of the alert of the rule
+func (r *Rule) newNotification(alert *Alert, lastData map[string]interface{}, state string) *Notification {
+
+	title := r.Description
+	if title == "" {
+		title = "Rule " + r.ID.String()
+	}
+	title = fmt.Sprintf("[%v] %v", strings.ToUpper(state), title)
+
+	sensor := r.Sensor
+	if sensor == "" {
+		sensor = "conditions"
+	}
+
+	return &Notification{
+		Title:       title,
+		Text:        fmt.Sprintf("%v is %v on device %v at %v", sensor, alert.Value, alert.DeviceID, lastData["collected_at"]),
+		State:       state,
+		Sensor:      r.Sensor,
+		Value:       alert.Value,
+		CollectedAt: fmt.Sprintf("%v", lastData["collected_at"]),
+		RuleID:      r.ID,
+		DeviceID:    alert.DeviceID,
+		AlertID:     alert.ID,
+	}
+}
+
+// postJSON sends the body as json and fails on non 2xx responses
+func postJSON(client *http.Client, url string, body []byte, headers map[string]string) error {
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return redactURLError(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return redactURLError(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded with status %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// redactURLError removes the url from the errors of the http client. The urls of
+// the Slack and Teams webhooks and of the Telegram bots are secrets, and the
+// errors are stored in the rule events and the deliveries.
+func redactURLError(err error) error {
+
+	if urlErr, ok := err.(*url.Error); ok {
+		return fmt.Errorf("%v request failed: %v", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// queueChannelNotification adds the notification of the channel to the outbox,
+// the delivery worker sends it with the current config of the channel
+func (r *Rule) queueChannelNotification(db *gorm.DB, alert *Alert, state string, channel *NotificationChannel, target string, n *Notification) {
+
+	event, _ := r.recordQueuedEvent(db, alert, state, channel.Type, target)
+
+	payload, _ := json.Marshal(n)
+
+	delivery := Delivery{
+		Payload:   string(payload),
+		ChannelID: &channel.ID,
+		RuleID:    r.ID,
+		TenantID:  r.TenantID,
+	}
+
+	if event != nil {
+		delivery.RuleEventID = &event.ID
+	}
+
+	_, err := delivery.SaveDelivery(db)
+	if err != nil && event != nil {
+		db.Model(event).Updates(map[string]interface{}{"status": RuleEventStatusFailed, "error": err.Error()})
+	}
+}
+
+// EmailNotifier sends the notification with the SMTP server of the api
+type EmailNotifier struct {
+	To string
+}
+
+func (e *EmailNotifier) Send(n *Notification) error {
+	return sendEmail([]string{e.To}, n.Title, n.Text)
+}
+
+// WebhookNotifier posts the notification as json, signed like the rule webhooks
+type WebhookNotifier struct {
+	Client        *http.Client
+	Url           string
+	Headers       map[string]string
+	SigningSecret string
+}
+
+func (wh *WebhookNotifier) Send(n *Notification) error {
+
+	body, _ := json.Marshal(n)
+
+	headers := map[string]string{}
+	for key, value := range wh.Headers {
+		headers[key] = value
+	}
+
+	if wh.SigningSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		headers["X-Siot-Timestamp"] = timestamp
+		headers["X-Siot-Signature"] = "sha256=" + signPayload(wh.SigningSecret, timestamp, string(body))
+	}
+
+	return postJSON(wh.Client, wh.Url, body, headers)
+}
+
+// SlackNotifier posts to a Slack incoming webhook
+type SlackNotifier struct {
+	Client     *http.Client
+	WebhookUrl string
+}
+
+func (s *SlackNotifier) Send(n *Notification) error {
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"text": fmt.Sprintf("*%v*\n%v", n.Title, n.Text),
+	})
+	return postJSON(s.Client, s.WebhookUrl, body, nil)
+}
+
+// TeamsNotifier posts a message card to a Microsoft Teams incoming webhook
+type TeamsNotifier struct {
+	Client     *http.Client
+	WebhookUrl string
+}
+
+func (t *TeamsNotifier) Send(n *Notification) error {
+
+	themeColor := "D9534F"
+	if n.State == AlertStateResolved {
+		themeColor = "5CB85C"
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    n.Title,
+		"themeColor": themeColor,
+		"title":      n.Title,
+		"text":       n.Text,
+	})
+	return postJSON(t.Client, t.WebhookUrl, body, nil)
+}
+
+// TelegramNotifier sends a message with a Telegram bot. ApiUrl defaults to the
+// Telegram bot api.
+type TelegramNotifier struct {
+	Client   *http.Client
+	ApiUrl   string
+	BotToken string
+	ChatID   string
+}
+
+func (t *TelegramNotifier) Send(n *Notification) error {
+
+	apiUrl := t.ApiUrl
+	if apiUrl == "" {
+		apiUrl = "https://api.telegram.org"
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"chat_id": t.ChatID,
+		"text":    n.Title + "\n" + n.Text,
+	})
+	return postJSON(t.Client, strings.TrimRight(apiUrl, "/")+"/bot"+t.BotToken+"/sendMessage", body, nil)
+}
@@ -0,0 +1,218 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// notifierRequest is the request received by newNotifierServer
+type notifierRequest struct {
+	Path   string
+	Header http.Header
+	Raw    string
+	Body   map[string]interface{}
+}
+
+// newNotifierServer records the last request and answers with status
+func newNotifierServer(t *testing.T, status int, got *notifierRequest) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got.Path, got.Header, got.Raw = r.URL.Path, r.Header, string(b)
+		got.Body = map[string]interface{}{}
+		if err := json.Unmarshal(b, &got.Body); err != nil {
+			t.Errorf("the body is not json: %q", b)
+		}
+		w.WriteHeader(status)
+	}))
+}
+
+func TestSlackNotifierBody(t *testing.T) {
+
+	var got notifierRequest
+	server := newNotifierServer(t, http.StatusOK, &got)
+	defer server.Close()
+
+	n := &SlackNotifier{Client: server.Client(), WebhookUrl: server.URL + "/services/T000/B000/token"}
+	if err := n.Send(&Notification{Title: "High temperature", Text: "temp is 42"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Path != "/services/T000/B000/token" || got.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected request %v %v", got.Path, got.Header)
+	}
+	if got.Body["text"] != "*High temperature*\ntemp is 42" {
+		t.Fatalf("unexpected text %q", got.Body["text"])
+	}
+}
+
+func TestTeamsNotifierCard(t *testing.T) {
+
+	tests := []struct {
+		state      string
+		themeColor string
+	}{
+		{AlertStateFiring, "D9534F"},
+		{AlertStateResolved, "5CB85C"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+
+			var got notifierRequest
+			server := newNotifierServer(t, http.StatusOK, &got)
+			defer server.Close()
+
+			n := &TeamsNotifier{Client: server.Client(), WebhookUrl: server.URL + "/webhookb2/token"}
+			if err := n.Send(&Notification{Title: "High temperature", Text: "temp is 42", State: tt.state}); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Body["@type"] != "MessageCard" || got.Body["themeColor"] != tt.themeColor {
+				t.Fatalf("unexpected card %v", got.Raw)
+			}
+			if got.Body["title"] != "High temperature" || got.Body["summary"] != "High temperature" || got.Body["text"] != "temp is 42" {
+				t.Fatalf("unexpected card %v", got.Raw)
+			}
+		})
+	}
+}
+
+func TestTelegramNotifierMessage(t *testing.T) {
+
+	var got notifierRequest
+	server := newNotifierServer(t, http.StatusOK, &got)
+	defer server.Close()
+
+	// the api url can end with a slash
+	n := &TelegramNotifier{Client: server.Client(), ApiUrl: server.URL + "/", BotToken: "123:abc", ChatID: "-10042"}
+	if err := n.Send(&Notification{Title: "High temperature", Text: "temp is 42"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Path != "/bot123:abc/sendMessage" {
+		t.Fatalf("unexpected path %v", got.Path)
+	}
+	if got.Body["chat_id"] != "-10042" || got.Body["text"] != "High temperature\ntemp is 42" {
+		t.Fatalf("unexpected message %v", got.Raw)
+	}
+}
+
+func TestWebhookNotifierSignature(t *testing.T) {
+
+	var got notifierRequest
+	server := newNotifierServer(t, http.StatusOK, &got)
+	defer server.Close()
+
+	n := &WebhookNotifier{Client: server.Client(), Url: server.URL, Headers: map[string]string{"X-Custom": "yes"}, SigningSecret: "s3cret"}
+	if err := n.Send(&Notification{Title: "High temperature", Text: "temp is 42", State: AlertStateFiring}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Header.Get("X-Custom") != "yes" || got.Body["title"] != "High temperature" || got.Body["state"] != AlertStateFiring {
+		t.Fatalf("unexpected request %v %v", got.Header, got.Raw)
+	}
+
+	// receivers recompute the HMAC of "<timestamp>.<body>"
+	timestamp := got.Header.Get("X-Siot-Timestamp")
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "." + got.Raw))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if timestamp == "" || got.Header.Get("X-Siot-Signature") != expected {
+		t.Fatalf("invalid signature %q for timestamp %q", got.Header.Get("X-Siot-Signature"), timestamp)
+	}
+
+	// channels without a secret are not signed
+	n.SigningSecret = ""
+	if err := n.Send(&Notification{Title: "High temperature"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Header.Get("X-Siot-Signature") != "" || got.Header.Get("X-Siot-Timestamp") != "" {
+		t.Fatal("unsigned channel sent a signature")
+	}
+}
+
+func TestNotifierErrorStatus(t *testing.T) {
+
+	var got notifierRequest
+	server := newNotifierServer(t, http.StatusBadRequest, &got)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		notifier Notifier
+	}{
+		{"webhook", &WebhookNotifier{Client: server.Client(), Url: server.URL}},
+		{"slack", &SlackNotifier{Client: server.Client(), WebhookUrl: server.URL}},
+		{"teams", &TeamsNotifier{Client: server.Client(), WebhookUrl: server.URL}},
+		{"telegram", &TelegramNotifier{Client: server.Client(), ApiUrl: server.URL, BotToken: "123:abc", ChatID: "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.notifier.Send(&Notification{Title: "title", Text: "text"})
+			if err == nil || !strings.Contains(err.Error(), "400") {
+				t.Fatalf("expected the status in the error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNotifierErrorsHideTheUrl(t *testing.T) {
+
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+
+	tests := []struct {
+		name     string
+		notifier Notifier
+	}{
+		{"slack connection refused", &SlackNotifier{Client: client, WebhookUrl: closed.URL + "/services/T000/B000/secret-token"}},
+		{"teams timeout", &TeamsNotifier{Client: client, WebhookUrl: slow.URL + "/webhookb2/secret-token"}},
+		{"telegram connection refused", &TelegramNotifier{Client: client, ApiUrl: closed.URL, BotToken: "123:secret-token", ChatID: "1"}},
+		{"invalid url", &SlackNotifier{Client: client, WebhookUrl: "https://hooks.example/secret-token/%zz"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.notifier.Send(&Notification{Title: "title", Text: "text"})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if strings.Contains(err.Error(), "secret-token") {
+				t.Fatalf("the error contains the url: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeliveryErrorsHideTheUrl(t *testing.T) {
+
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	d := Delivery{Url: closed.URL + "/hook?token=secret-token", Payload: "{}", Status: DeliveryStatusPending, MaxAttempts: 5}
+
+	statusCode, permanent, err := d.send(closed.Client(), "")
+	d.recordAttempt(time.Now(), statusCode, err, permanent)
+
+	if d.LastError == "" || strings.Contains(d.LastError, "secret-token") {
+		t.Fatalf("unexpected error %q", d.LastError)
+	}
+}
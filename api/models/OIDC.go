@@ -0,0 +1,297 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"siot/api/auth"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// time the user has to log in with the identity provider
+const oidcLoginTTL = 10 * time.Minute
+
+// OIDCLogin keeps the state, nonce and PKCE verifier of a single sign-on between
+// the redirect to the identity provider and the callback
+type OIDCLogin struct {
+	ID           uuid.UUID `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	StateHash    string    `gorm:"size:255;not null;unique" json:"-"`
+	Nonce        string    `gorm:"size:255;not null" json:"-"`
+	CodeVerifier string    `gorm:"size:255;not null" json:"-"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func (l *OIDCLogin) BeforeCreate() {
+
+	l.CreatedAt = time.Now()
+}
+
+// OIDCGroupMapping adds the users of a group of the identity provider to a tenant
+type OIDCGroupMapping struct {
+	Group    string
+	TenantID uuid.UUID
+	Role     string
+}
+
+// oidcGroupsClaim is OIDC_GROUPS_CLAIM, the claim of the id token with the groups
+// of the user, default groups
+func oidcGroupsClaim() string {
+
+	if claim := strings.TrimSpace(os.Getenv("OIDC_GROUPS_CLAIM")); claim != "" {
+		return claim
+	}
+	return "groups"
+}
+
+// oidcGroupMappings reads OIDC_GROUP_MAPPINGS, a list of group=tenant_id:role
+// separated by semicolons. The role is optional, editor by default.
+func oidcGroupMappings() []OIDCGroupMapping {
+
+	mappings := []OIDCGroupMapping{}
+
+	for _, entry := range strings.Split(os.Getenv("OIDC_GROUP_MAPPINGS"), ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			log.Printf("oidc: invalid group mapping %q", entry)
+			continue
+		}
+
+		target := strings.SplitN(parts[1], ":", 2)
+		tenant_id, err := uuid.Parse(strings.TrimSpace(target[0]))
+		if err != nil {
+			log.Printf("oidc: invalid tenant of the group mapping %q", entry)
+			continue
+		}
+
+		role := RoleEditor
+		if len(target) == 2 {
+			role = strings.ToLower(strings.TrimSpace(target[1]))
+		}
+		if !IsValidRole(role) {
+			log.Printf("oidc: invalid role of the group mapping %q", entry)
+			continue
+		}
+
+		mappings = append(mappings, OIDCGroupMapping{
+			Group:    strings.TrimSpace(parts[0]),
+			TenantID: tenant_id,
+			Role:     role,
+		})
+	}
+	return mappings
+}
+
+// claimGroups returns the groups of the claim, sent as a list or a single string
+func claimGroups(claims jwt.MapClaims) []string {
+
+	groups := []string{}
+
+	switch value := claims[oidcGroupsClaim()].(type) {
+	case string:
+		groups = append(groups, value)
+	case []interface{}:
+		for _, group := range value {
+			groups = append(groups, fmt.Sprintf("%v", group))
+		}
+	}
+	return groups
+}
+
+// StartOIDCLogin stores a new state and returns the authorization url of the
+// identity provider
+func StartOIDCLogin(db *gorm.DB, provider *auth.OIDCProvider) (string, error) {
+
+	// expired logins are not used anymore
+	db.Where("expires_at < ?", time.Now()).Delete(&OIDCLogin{})
+
+	state := randStr(16)
+	login := OIDCLogin{
+		StateHash:    hashApiKey(state),
+		Nonce:        randStr(16),
+		CodeVerifier: randStr(32),
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	}
+
+	var err error = db.Create(&login).Error
+	if err != nil {
+		return "", err
+	}
+
+	return provider.AuthCodeURL(state, login.Nonce, login.CodeVerifier)
+}
+
+// CompleteOIDCLogin checks the state of the callback, exchanges the code and
+// returns the user of the id token, provisioned on the first login
+func CompleteOIDCLogin(db *gorm.DB, provider *auth.OIDCProvider, code string, state string, ip string) (*User, error) {
+
+	if code == "" || state == "" {
+		return nil, errors.New("code and state are required")
+	}
+
+	login := OIDCLogin{}
+	if db.Where("state_hash = ?", hashApiKey(state)).Take(&login).Error != nil {
+		return nil, errors.New("invalid state")
+	}
+
+	// a state is used once
+	result := db.Where("id = ?", login.ID).Delete(&OIDCLogin{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("invalid state")
+	}
+	if login.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("the login expired, try again")
+	}
+
+	idToken, err := provider.Exchange(code, login.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := provider.VerifyIDToken(idToken, login.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// locked accounts can not sign in with the identity provider either, the
+	// lockout is checked before the user is created or added to tenants
+	userEmail, _ := claims["email"].(string)
+	if err := CheckLockout(db, userEmail, ip); err != nil {
+		return nil, err
+	}
+
+	return provisionOIDCUser(db, claims)
+}
+
+// provisionOIDCUser maps the email of the id token to a user, creates it when it
+// does not exist and adds it to the tenants of its groups
+func provisionOIDCUser(db *gorm.DB, claims jwt.MapClaims) (*User, error) {
+
+	userEmail, _ := claims["email"].(string)
+	userEmail = strings.TrimSpace(userEmail)
+	if userEmail == "" {
+		return nil, errors.New("the id token does not have an email")
+	}
+	// the email links the login to an existing account, providers that do not
+	// send email_verified are only accepted with OIDC_ALLOW_UNVERIFIED_EMAIL
+	if verified, _ := claims["email_verified"].(bool); !verified && os.Getenv("OIDC_ALLOW_UNVERIFIED_EMAIL") != "true" {
+		return nil, errors.New("the email of the identity provider is not verified")
+	}
+
+	user := User{}
+	err := db.Where("lower(email) = lower(?)", userEmail).Take(&user).Error
+
+	switch {
+	case gorm.IsRecordNotFoundError(err):
+		created, err := createOIDCUser(db, claims, userEmail)
+		if err != nil {
+			return nil, err
+		}
+		user = *created
+
+	case err != nil:
+		return nil, err
+
+	case user.Status == "inactive":
+		return nil, errors.New("user is inactive")
+
+	case user.Status != "active":
+		// the identity provider confirmed the email of the invitation
+		var errUpdate error = db.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"status":           "active",
+			"invitation_token": "",
+			"updated_at":       time.Now(),
+		}).Error
+		if errUpdate != nil {
+			return nil, errUpdate
+		}
+		user.Status = "active"
+	}
+
+	if err := user.joinOIDCTenants(db, claimGroups(claims)); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// createOIDCUser creates an active user without a usable password
+func createOIDCUser(db *gorm.DB, claims jwt.MapClaims, userEmail string) (*User, error) {
+
+	firstName, _ := claims["given_name"].(string)
+	lastName, _ := claims["family_name"].(string)
+	if firstName == "" {
+		name, _ := claims["name"].(string)
+		firstName = name
+	}
+	if firstName == "" {
+		firstName = strings.Split(userEmail, "@")[0]
+	}
+
+	hashedPassword, err := Hash(randStr(32))
+	if err != nil {
+		return nil, err
+	}
+
+	user := User{
+		FirstName: html.EscapeString(strings.TrimSpace(firstName)),
+		LastName:  html.EscapeString(strings.TrimSpace(lastName)),
+		Email:     html.EscapeString(userEmail),
+		Password:  string(hashedPassword),
+		Status:    "active",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	var errCreate error = db.Create(&user).Error
+	if errCreate != nil {
+		return nil, errCreate
+	}
+	return &user, nil
+}
+
+// joinOIDCTenants adds the user to the tenants mapped to its groups, the role of
+// existing members is not changed
+func (u *User) joinOIDCTenants(db *gorm.DB, groups []string) error {
+
+	for _, mapping := range oidcGroupMappings() {
+		for _, group := range groups {
+			if group != mapping.Group {
+				continue
+			}
+
+			if u.BelongsToTenant(db, mapping.TenantID.String(), u.ID.String()) {
+				continue
+			}
+
+			var count int
+			db.Model(&Tenant{}).Where("id = ?", mapping.TenantID).Count(&count)
+			if count == 0 {
+				log.Printf("oidc: the tenant %v of the group %v does not exist", mapping.TenantID, mapping.Group)
+				continue
+			}
+
+			var err error = db.Create(&UserTenant{UserID: u.ID, TenantID: mapping.TenantID, Role: mapping.Role}).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestProvisionOIDCUserRequiresVerifiedEmail(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{"missing email", jwt.MapClaims{"email_verified": true}},
+		{"unverified email", jwt.MapClaims{"email": "admin@example.com", "email_verified": false}},
+		{"missing email_verified", jwt.MapClaims{"email": "admin@example.com"}},
+		{"email_verified as string", jwt.MapClaims{"email": "admin@example.com", "email_verified": "true"}},
+	}
+
+	// the claims are rejected before the users are read
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := provisionOIDCUser(nil, tt.claims); err == nil {
+				t.Fatal("expected the id token to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// operations available to aggregate the values of a sensor in a rule
+var ruleOperations = []string{"sum", "mean", "median", "max", "min", "delta", "rate", "rate_per_minute", "stddev", "percentile", "count"}
+
+func isValidOperation(operation string) bool {
+
+	for _, o := range ruleOperations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+func availableOperations() string {
+	return strings.Join(ruleOperations[:len(ruleOperations)-1], ", ") + " and " + ruleOperations[len(ruleOperations)-1]
+}
+
+// numericValues returns the numeric values of the sensor, non numeric values are skipped
+func numericValues(sensor string, data []map[string]interface{}) []float64 {
+
+	var values []float64
+	for _, value := range data {
+		if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", value[sensor]), 64); err == nil {
+			values = append(values, deviceValue)
+		}
+	}
+	return values
+}
+
+// deltaValue is the newest value minus the oldest one. The data is sorted from the
+// newest to the oldest, so a counter reset gives a negative delta.
+func deltaValue(sensor string, data []map[string]interface{}) float64 {
+
+	values := numericValues(sensor, data)
+	if len(values) < 2 {
+		return 0
+	}
+	return values[0] - values[len(values)-1]
+}
+
+// rateValue is the delta divided by the time between the oldest and the newest
+// value, expressed per unit
+func rateValue(sensor string, data []map[string]interface{}, unit time.Duration) float64 {
+
+	var newest, oldest map[string]interface{}
+	for _, value := range data {
+		if _, err := strconv.ParseFloat(fmt.Sprintf("%v", value[sensor]), 64); err == nil {
+			if newest == nil {
+				newest = value
+			}
+			oldest = value
+		}
+	}
+
+	if newest == nil {
+		return 0
+	}
+
+	newestAt, errNewest := time.Parse("2006-01-02T15:04:05.000Z", fmt.Sprintf("%v", newest["collected_at"]))
+	oldestAt, errOldest := time.Parse("2006-01-02T15:04:05.000Z", fmt.Sprintf("%v", oldest["collected_at"]))
+	if errNewest != nil || errOldest != nil || !newestAt.After(oldestAt) {
+		return 0
+	}
+
+	elapsed := newestAt.Sub(oldestAt)
+	return deltaValue(sensor, data) / (float64(elapsed) / float64(unit))
+}
+
+// stddevValue is the population standard deviation of the values
+func stddevValue(values []float64) float64 {
+
+	if len(values) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum = sum + v
+	}
+	mean := sum / float64(len(values))
+
+	var squares float64
+	for _, v := range values {
+		squares = squares + (v-mean)*(v-mean)
+	}
+	return math.Sqrt(squares / float64(len(values)))
+}
+
+// percentileValue interpolates linearly between the closest ranks of the values
+func percentileValue(values []float64, percentile float64) float64 {
+
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	percentile = math.Max(0, math.Min(100, percentile))
+
+	rank := percentile / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
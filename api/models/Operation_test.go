@@ -0,0 +1,102 @@
+package models
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// samples builds the data of the sensor temp sorted from the newest to the
+// oldest, like the data read from mongo, with step between two values
+func samples(step time.Duration, values ...interface{}) []map[string]interface{} {
+
+	newest := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	data := []map[string]interface{}{}
+	for i, value := range values {
+		collectedAt := newest.Add(-time.Duration(i) * step)
+		data = append(data, map[string]interface{}{
+			"temp":         value,
+			"collected_at": collectedAt.Format("2006-01-02T15:04:05.000Z"),
+		})
+	}
+	return data
+}
+
+func TestCalculateOperation(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		operation  string
+		percentile float64
+		data       []map[string]interface{}
+		expected   float64
+	}{
+		{"delta empty", "delta", 0, samples(time.Minute), 0},
+		{"delta single sample", "delta", 0, samples(time.Minute, 10), 0},
+		{"delta", "delta", 0, samples(time.Minute, 10, 7, 4), 6},
+		{"delta counter reset", "delta", 0, samples(time.Minute, 2, 10), -8},
+		{"delta non numeric", "delta", 0, samples(time.Minute, "n/a", 12, "error", 5), 7},
+
+		{"rate empty", "rate", 0, samples(time.Minute), 0},
+		{"rate single sample", "rate", 0, samples(time.Minute, 30), 0},
+		{"rate", "rate", 0, samples(time.Minute, 30, 15, 0), 0.25},
+		{"rate non numeric", "rate", 0, samples(time.Minute, "n/a", 30, 0), 0.5},
+		{"rate same time", "rate", 0, samples(0, 30, 0), 0},
+
+		{"rate per minute empty", "rate_per_minute", 0, samples(time.Minute), 0},
+		{"rate per minute single sample", "rate_per_minute", 0, samples(time.Minute, 30), 0},
+		{"rate per minute", "rate_per_minute", 0, samples(time.Minute, 30, 15, 0), 15},
+		{"rate per minute seconds", "rate_per_minute", 0, samples(30*time.Second, 5, 0), 10},
+
+		{"stddev empty", "stddev", 0, samples(time.Minute), 0},
+		{"stddev single sample", "stddev", 0, samples(time.Minute, 42), 0},
+		{"stddev", "stddev", 0, samples(time.Minute, 2, 4, 4, 4, 5, 5, 7, 9), 2},
+		{"stddev non numeric", "stddev", 0, samples(time.Minute, 2, "n/a", 4, 4, 4, 5, 5, 7, 9), 2},
+
+		{"percentile empty", "percentile", 50, samples(time.Minute), 0},
+		{"percentile single sample", "percentile", 90, samples(time.Minute, 5), 5},
+		{"percentile median", "percentile", 50, samples(time.Minute, 40, 10, 30, 20), 25},
+		{"percentile interpolated", "percentile", 90, samples(time.Minute, 40, 10, 30, 20), 37},
+		{"percentile lower bound", "percentile", 0, samples(time.Minute, 40, 10, 30, 20), 10},
+		{"percentile upper bound", "percentile", 100, samples(time.Minute, 40, 10, 30, 20), 40},
+		{"percentile below range", "percentile", -10, samples(time.Minute, 40, 10, 30, 20), 10},
+		{"percentile above range", "percentile", 150, samples(time.Minute, 40, 10, 30, 20), 40},
+		{"percentile non numeric", "percentile", 100, samples(time.Minute, "n/a", 10, 20), 20},
+
+		{"count empty", "count", 0, samples(time.Minute), 0},
+		{"count single sample", "count", 0, samples(time.Minute, 1), 1},
+		{"count", "count", 0, samples(time.Minute, 1, 2, 3), 3},
+		{"count non numeric", "count", 0, samples(time.Minute, 1, "n/a", true), 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Condition{Sensor: "temp", Operation: tt.operation, Percentile: tt.percentile}
+			if value := calculateOperation(c, tt.data); math.Abs(value-tt.expected) > 1e-9 {
+				t.Fatalf("expected %v, got %v", tt.expected, value)
+			}
+		})
+	}
+}
+
+func TestRateMissingCollectedAt(t *testing.T) {
+
+	data := []map[string]interface{}{{"temp": 30}, {"temp": 0}}
+
+	if value := rateValue("temp", data, time.Second); value != 0 {
+		t.Fatalf("expected 0 without timestamps, got %v", value)
+	}
+}
+
+func TestIsValidOperation(t *testing.T) {
+
+	for _, operation := range []string{"delta", "rate", "rate_per_minute", "stddev", "percentile", "count"} {
+		if !isValidOperation(operation) {
+			t.Fatalf("expected %v to be valid", operation)
+		}
+	}
+	if isValidOperation("variance") {
+		t.Fatal("expected variance to be invalid")
+	}
+}
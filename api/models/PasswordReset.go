@@ -0,0 +1,211 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"siot/api/utils/email"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// PasswordReset is a single use token sent by email to choose a new password,
+// only its hash is stored
+type PasswordReset struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	TokenHash string     `gorm:"size:255;not null;unique" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	UserID    uuid.UUID  `sql:"type:uuid REFERENCES users(id) ON DELETE CASCADE" json:"-"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func (p *PasswordReset) BeforeCreate() {
+
+	p.CreatedAt = time.Now()
+}
+
+// passwordResetTTL is PASSWORD_RESET_TTL, default 1h
+func passwordResetTTL() time.Duration {
+	return envDuration("PASSWORD_RESET_TTL", time.Hour)
+}
+
+// passwordResetMaxRequests is PASSWORD_RESET_MAX_REQUESTS, the reset emails
+// requested for an email in an hour, default 3
+func passwordResetMaxRequests() int {
+	return envInt("PASSWORD_RESET_MAX_REQUESTS", 3)
+}
+
+// passwordResetIPMaxRequests is PASSWORD_RESET_IP_MAX_REQUESTS, the reset emails
+// requested by an ip address in an hour, default 20
+func passwordResetIPMaxRequests() int {
+	return envInt("PASSWORD_RESET_IP_MAX_REQUESTS", 20)
+}
+
+// passwordValidations checks the strength of a password: at least 8 characters
+// with lower and upper case letters and digits, and different from the email
+func passwordValidations(password string, userEmail string) []string {
+
+	var errs []string
+
+	if password == "" {
+		return append(errs, "password is required")
+	}
+	if len(password) < 8 {
+		errs = append(errs, "password must have at least 8 characters")
+	}
+	if len(password) > 72 {
+		errs = append(errs, "password must have at most 72 characters")
+	}
+
+	var lower, upper, digit bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		}
+	}
+	if !lower || !upper || !digit {
+		errs = append(errs, "password must have lower case letters, upper case letters and digits")
+	}
+
+	if userEmail != "" && strings.EqualFold(password, userEmail) {
+		errs = append(errs, "password must be different from the email")
+	}
+
+	return errs
+}
+
+// RequestPasswordReset emails a reset token to the user. Unknown emails are
+// ignored so the response does not tell which accounts exist. The requests of an
+// email and of an ip address are throttled like the logins, it returns a
+// LoginLockedError when there are too many.
+func RequestPasswordReset(db *gorm.DB, userEmail string, ip string) error {
+
+	// every request is counted, known emails or not
+	limits := []throttleLimit{
+		{Key: "reset:" + emailThrottleKey(userEmail), Max: passwordResetMaxRequests(), Window: time.Hour},
+	}
+	if ip != "" {
+		limits = append(limits, throttleLimit{Key: "reset:" + ipThrottleKey(ip), Max: passwordResetIPMaxRequests(), Window: time.Hour})
+	}
+	if _, err := reserveThrottles(db, limits); err != nil {
+		return err
+	}
+
+	user := User{}
+	if db.Where("email = ?", strings.TrimSpace(userEmail)).Take(&user).Error != nil {
+		return nil
+	}
+	if user.Status == "inactive" {
+		return nil
+	}
+
+	// the tokens sent before stay valid until one of them is used
+	now := time.Now()
+	token := randStr(32)
+	reset := PasswordReset{
+		TokenHash: hashApiKey(token),
+		ExpiresAt: now.Add(passwordResetTTL()),
+		UserID:    user.ID,
+	}
+
+	var errCreate error = db.Create(&reset).Error
+	if errCreate != nil {
+		return errCreate
+	}
+
+	go email.SendPasswordResetEmail([]string{user.Email}, "[SIOT] Reset your password", user.FirstName, user.LastName, token, formatDuration(passwordResetTTL()))
+
+	return nil
+}
+
+// ResetPassword sets the password of the user of the token and revokes the
+// tokens of the user
+func ResetPassword(db *gorm.DB, token string, password string) error {
+
+	reset := PasswordReset{}
+	if token == "" || db.Where("token_hash = ?", hashApiKey(token)).Take(&reset).Error != nil {
+		return errors.New("invalid reset token")
+	}
+	if reset.UsedAt != nil {
+		return errors.New("reset token was already used")
+	}
+	if reset.ExpiresAt.Before(time.Now()) {
+		return errors.New("reset token is expired")
+	}
+
+	user := User{}
+	if db.Where("id = ?", reset.UserID).Take(&user).Error != nil {
+		return errors.New("user not found")
+	}
+
+	if errs := passwordValidations(password, user.Email); len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+
+	// mark the token as used first, so it can not be used twice at the same time
+	result := db.Model(&PasswordReset{}).Where("id = ? AND used_at IS NULL", reset.ID).UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("reset token was already used")
+	}
+
+	// the other reset tokens of the user can not be used anymore
+	db.Model(&PasswordReset{}).Where("user_id = ? AND used_at IS NULL", user.ID).UpdateColumn("used_at", time.Now())
+
+	if err := user.setPassword(db, password); err != nil {
+		return err
+	}
+
+	// the owner of the email chose a new password, the lockout ends
+	RecordLoginSuccess(db, user.Email)
+	return nil
+}
+
+// ChangePassword replaces the password of the user after checking the current one
+func (u *User) ChangePassword(db *gorm.DB, user_id string, current string, password string) error {
+
+	var err error = db.Model(&User{}).Where("id = ?", user_id).Take(&u).Error
+	if err != nil {
+		return err
+	}
+
+	if VerifyPassword(u.Password, current) != nil {
+		return errors.New("current password is incorrect")
+	}
+	if current == password {
+		return errors.New("password must be different from the current password")
+	}
+
+	return u.setPassword(db, password)
+}
+
+// setPassword stores the hash of the password and revokes every token of the user
+func (u *User) setPassword(db *gorm.DB, password string) error {
+
+	hashedPassword, err := Hash(password)
+	if err != nil {
+		return err
+	}
+
+	var errUpdate error = db.Model(&User{}).Where("id = ?", u.ID).Updates(map[string]interface{}{
+		"password":   string(hashedPassword),
+		"updated_at": time.Now(),
+	}).Error
+	if errUpdate != nil {
+		return fmt.Errorf("cannot update the password: %v", errUpdate)
+	}
+
+	return RevokeUserTokens(db, u.ID)
+}
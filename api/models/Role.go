@@ -0,0 +1,94 @@
+package models
+
+// roles of a user inside a tenant
+const (
+	RoleOwner          = "owner"
+	RoleAdmin          = "admin"
+	RoleEditor         = "editor"
+	RoleViewer         = "viewer"
+	RoleDeviceOperator = "device-operator"
+)
+
+// permissions checked on tenant routes
+const (
+	PermissionTenantRead    = "tenant:read"
+	PermissionTenantWrite   = "tenant:write"
+	PermissionMembersRead   = "members:read"
+	PermissionMembersWrite  = "members:write"
+	PermissionDevicesRead   = "devices:read"
+	PermissionDevicesWrite  = "devices:write"
+	PermissionSensorsRead   = "sensors:read"
+	PermissionSensorsWrite  = "sensors:write"
+	PermissionDataRead      = "data:read"
+	PermissionCommandsRead  = "commands:read"
+	PermissionCommandsWrite = "commands:write"
+	PermissionRulesRead     = "rules:read"
+	PermissionRulesWrite    = "rules:write"
+	PermissionAlertsWrite   = "alerts:write"
+	PermissionApiKeysRead   = "api_keys:read"
+	PermissionApiKeysWrite  = "api_keys:write"
+)
+
+var readPermissions = []string{
+	PermissionTenantRead,
+	PermissionMembersRead,
+	PermissionDevicesRead,
+	PermissionSensorsRead,
+	PermissionDataRead,
+	PermissionCommandsRead,
+	PermissionRulesRead,
+}
+
+var rolePermissions = map[string][]string{
+	RoleOwner: append([]string{
+		PermissionTenantWrite,
+		PermissionMembersWrite,
+		PermissionApiKeysRead,
+		PermissionApiKeysWrite,
+		PermissionDevicesWrite,
+		PermissionSensorsWrite,
+		PermissionCommandsWrite,
+		PermissionRulesWrite,
+		PermissionAlertsWrite,
+	}, readPermissions...),
+	RoleAdmin: append([]string{
+		PermissionTenantWrite,
+		PermissionMembersWrite,
+		PermissionApiKeysRead,
+		PermissionApiKeysWrite,
+		PermissionDevicesWrite,
+		PermissionSensorsWrite,
+		PermissionCommandsWrite,
+		PermissionRulesWrite,
+		PermissionAlertsWrite,
+	}, readPermissions...),
+	RoleEditor: append([]string{
+		PermissionDevicesWrite,
+		PermissionSensorsWrite,
+		PermissionCommandsWrite,
+		PermissionRulesWrite,
+		PermissionAlertsWrite,
+	}, readPermissions...),
+	RoleDeviceOperator: append([]string{
+		PermissionDevicesWrite,
+		PermissionSensorsWrite,
+		PermissionCommandsWrite,
+		PermissionAlertsWrite,
+	}, readPermissions...),
+	RoleViewer: readPermissions,
+}
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func RoleHasPermission(role string, permission string) bool {
+
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
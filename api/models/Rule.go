@@ -34,63 +34,119 @@ func (j JSONB) Value() (driver.Value, error) {
 }
 
 func (j *JSONB) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
 	if err := json.Unmarshal(value.([]byte), &j); err != nil {
 		return err
 	}
 	return nil
 }
 
+// types of rules
+const (
+	RuleTypeThreshold = "threshold"
+	RuleTypeNoData    = "no_data"
+)
+
 type Rule struct {
-	ID                      uuid.UUID `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
-	Sensor                  string    `validate:"required" gorm:"size:255;not null;" json:"sensor"`
-	Description             string    `gorm:"size:255;" json:"description"`
-	Operation               string    `gorm:"size:255;" json:"operation"`
-	CountLatest             int64     `gorm:"default:1;" json:"count_latest"`
-	Email                   string    `gorm:"size:255;" json:"email"`
-	EmailSubject            string    `gorm:"size:255;" json:"email_subject"`
-	EmailBody               string    `gorm:"size:255;" json:"email_body"`
-	EndpointUrl             string    `gorm:"size:255;" json:"endpoint_url"`
-	EndpointHeader          JSONB     `sql:"type:jsonb" gorm:"size:255;" json:"endpoint_header"`
-	EndpointPayload         JSONB     `sql:"type:jsonb" gorm:"size:255;" json:"endpoint_payload"`
-	Operator                string    `gorm:"size:255;" json:"operator"`
-	Value                   string    `gorm:"size:255;" json:"value"`
-	TimeBetweenNotification string    `gorm:"size:255;" json:"time_between_notification"`
-	LastNotification        time.Time `gorm:"size:255;" json:"last_notification"`
-	DeviceID                uuid.UUID `sql:"type:uuid REFERENCES devices(id) ON DELETE CASCADE" json:"device_id"`
-	TenantID                uuid.UUID `sql:"type:uuid REFERENCES tenants(id) ON DELETE CASCADE" json:"-"`
-	Status                  string    `gorm:"size:255;" json:"status"`
-	CreatedAt               time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt               time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ID                      uuid.UUID   `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	Type                    string      `gorm:"size:255;" json:"type"`
+	Sensor                  string      `validate:"required" gorm:"size:255;not null;" json:"sensor"`
+	Description             string      `gorm:"size:255;" json:"description"`
+	Operation               string      `gorm:"size:255;" json:"operation"`
+	CountLatest             int64       `gorm:"default:1;" json:"count_latest"`
+	Window                  string      `gorm:"size:255;" json:"window"`
+	MinSamples              int64       `gorm:"default:0;" json:"min_samples"`
+	Percentile              float64     `gorm:"default:0;" json:"percentile"`
+	Email                   string      `gorm:"size:255;" json:"email"`
+	EmailSubject            string      `gorm:"size:255;" json:"email_subject"`
+	EmailBody               string      `gorm:"size:255;" json:"email_body"`
+	EndpointUrl             string      `gorm:"size:255;" json:"endpoint_url"`
+	EndpointHeader          JSONB       `sql:"type:jsonb" gorm:"size:255;" json:"endpoint_header"`
+	EndpointPayload         JSONB       `sql:"type:jsonb" gorm:"size:255;" json:"endpoint_payload"`
+	Conditions              JSONB       `sql:"type:jsonb" json:"conditions"`
+	Schedule                JSONB       `sql:"type:jsonb" json:"schedule"`
+	SigningSecret           string      `gorm:"size:255;" json:"-"`
+	ChannelIDs              []uuid.UUID `gorm:"-" json:"channel_ids"`
+	EscalationPolicyID      *uuid.UUID  `sql:"type:uuid REFERENCES escalation_policies(id) ON DELETE SET NULL" json:"escalation_policy_id"`
+	CommandPayload          JSONB       `sql:"type:jsonb" json:"command_payload"`
+	CommandTTL              string      `gorm:"size:255;" json:"command_ttl"`
+	Operator                string      `gorm:"size:255;" json:"operator"`
+	Value                   string      `gorm:"size:255;" json:"value"`
+	TimeBetweenNotification string      `gorm:"size:255;" json:"time_between_notification"`
+	For                     string      `gorm:"size:255;" json:"for"`
+	Hysteresis              float64     `gorm:"default:0" json:"hysteresis"`
+	LastNotification        time.Time   `gorm:"size:255;" json:"last_notification"`
+	Target                  string      `gorm:"size:255;" json:"target"`
+	TargetValue             string      `gorm:"size:255;" json:"target_value"`
+	DeviceID                *uuid.UUID  `sql:"type:uuid REFERENCES devices(id) ON DELETE CASCADE" json:"device_id"`
+	TenantID                uuid.UUID   `sql:"type:uuid REFERENCES tenants(id) ON DELETE CASCADE" json:"-"`
+	Status                  string      `gorm:"size:255;" json:"status"`
+	CreatedAt               time.Time   `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt               time.Time   `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// RuleWithSecret is returned when the signing secret is created or rotated, the
+// secret is not part of the other responses
+type RuleWithSecret struct {
+	*Rule
+	SigningSecret string `json:"signing_secret,omitempty"`
 }
 
 func (r *Rule) BeforeCreate() {
 
 	r.Description = html.EscapeString(strings.TrimSpace(r.Description))
 	r.Status = strings.ToLower(r.Status)
+	r.Type = strings.ToLower(strings.TrimSpace(r.Type))
 	r.Operation = html.EscapeString(strings.TrimSpace(r.Operation))
 	r.Email = html.EscapeString(strings.TrimSpace(r.Email))
 	r.EndpointUrl = html.EscapeString(strings.TrimSpace(r.EndpointUrl))
 	r.Operator = html.EscapeString(strings.TrimSpace(r.Operator))
 	r.Value = html.EscapeString(strings.TrimSpace(r.Value))
 	r.TimeBetweenNotification = html.EscapeString(strings.TrimSpace(r.TimeBetweenNotification))
+	r.CommandTTL = strings.TrimSpace(r.CommandTTL)
+	r.For = strings.TrimSpace(r.For)
+	r.Window = strings.TrimSpace(r.Window)
+	r.prepareTarget()
 	r.CreatedAt = time.Now()
 	r.UpdatedAt = time.Now()
 
 	if r.Status != "active" && r.Status != "inactive" {
 		r.Status = "active"
 	}
+
+	if r.Type == "" {
+		r.Type = RuleTypeThreshold
+	}
+
+	if r.Target == "" {
+		r.Target = RuleTargetDevice
+	}
+
+	// webhook requests are signed with a secret generated by the server
+	r.SigningSecret = ""
+	if r.EndpointUrl != "" {
+		r.SigningSecret = randStr(32)
+	}
 }
 
 func (r *Rule) PrepareUpdate() {
 
 	r.Description = html.EscapeString(strings.TrimSpace(r.Description))
 	r.Status = strings.ToLower(r.Status)
+	r.Type = strings.ToLower(strings.TrimSpace(r.Type))
 	r.Operation = html.EscapeString(strings.TrimSpace(r.Operation))
 	r.Email = html.EscapeString(strings.TrimSpace(r.Email))
 	r.EndpointUrl = html.EscapeString(strings.TrimSpace(r.EndpointUrl))
 	r.Operator = html.EscapeString(strings.TrimSpace(r.Operator))
 	r.Value = html.EscapeString(strings.TrimSpace(r.Value))
 	r.TimeBetweenNotification = html.EscapeString(strings.TrimSpace(r.TimeBetweenNotification))
+	r.CommandTTL = strings.TrimSpace(r.CommandTTL)
+	r.For = strings.TrimSpace(r.For)
+	r.Window = strings.TrimSpace(r.Window)
+	r.prepareTarget()
+	r.SigningSecret = ""
 	r.UpdatedAt = time.Now()
 
 	if r.Status != "active" && r.Status != "inactive" {
@@ -98,10 +154,12 @@ func (r *Rule) PrepareUpdate() {
 	}
 }
 
+// updateNotificationTime only writes the column, the rule can be a cached copy
+// older than the stored one
 func (r *Rule) updateNotificationTime(db *gorm.DB) error {
 
 	r.LastNotification = time.Now()
-	var err error = db.Model(&Rule{}).Where("id = ?", r.ID).Updates(&r).Error
+	var err error = db.Model(&Rule{}).Where("id = ?", r.ID).UpdateColumn("last_notification", r.LastNotification).Error
 
 	if err != nil {
 		return err
@@ -110,24 +168,71 @@ func (r *Rule) updateNotificationTime(db *gorm.DB) error {
 	return nil
 }
 
-func (r *Rule) SendNotificationEmail(lastData map[string]interface{}) {
+func (r *Rule) notify(db *gorm.DB, alert *Alert, lastData map[string]interface{}, state string) {
+
+	// the alert changes state during maintenance but nobody is notified
+	if window := activeMaintenanceWindow(db, r.TenantID, alert.DeviceID); window != nil {
+		r.recordSuppressedEvent(db, alert, state, window)
+		return
+	}
+
+	r.updateNotificationTime(db)
+	alert.updateNotificationTime(db)
+
+	ctx := r.newTemplateContext(db, alert.DeviceID, alert.Value, lastData, state)
+
+	if r.Email != "" {
+		err := r.SendNotificationEmail(ctx)
+		r.recordEvent(db, alert, state, "email", r.Email, err)
+	}
+	if r.EndpointUrl != "" {
+		event, _ := r.recordQueuedEvent(db, alert, state, "webhook", r.EndpointUrl)
+		err := r.sendRequestNotification(db, event, ctx)
+		if err != nil && event != nil {
+			db.Model(event).Updates(map[string]interface{}{"status": RuleEventStatusFailed, "error": err.Error()})
+		}
+	}
+
+	// notification channels of the rule
+	channels := r.ruleChannels(db)
+	if len(channels) > 0 {
+		notification := r.newNotification(alert, lastData, state)
+
+		for i := 0; i < len(channels); i++ {
+			r.queueChannelNotification(db, alert, state, &channels[i], channels[i].Name, notification)
+		}
+	}
+
+	// enqueue a command to the device as a rule action
+	if len(r.CommandPayload) > 0 && state == AlertStateFiring {
+		command := Command{
+			Payload: r.CommandPayload,
+			TTL:     r.CommandTTL,
+			RuleID:  &r.ID,
+		}
+		_, err := command.SaveCommand(db, r.TenantID, alert.DeviceID)
+		r.recordEvent(db, alert, state, "command", alert.DeviceID.String(), err)
+	}
+
+	// escalation steps that are due, the first ones usually notify at once
+	if state == AlertStateFiring {
+		r.escalate(db, alert)
+	}
+}
+
+// SendNotificationEmail renders the subject and body templates of the rule and sends the email
+func (r *Rule) SendNotificationEmail(ctx *TemplateContext) error {
 
-	// email info
-	var to []string
-	to = append(to, r.Email)
-	subject := r.EmailSubject
-	msg := r.EmailBody
+	rendered, err := r.RenderTemplates(ctx)
+	if err != nil {
+		return err
+	}
 
-	// replace subject and msg with data values if exists
-	subject = strings.Replace(subject, "$value", fmt.Sprintf("%v", lastData[r.Sensor]), -1)
-	subject = strings.Replace(subject, "$collected_at", fmt.Sprintf("%v", lastData["collected_at"]), -1)
-	subject = strings.Replace(subject, "$device_id", fmt.Sprintf("%v", r.DeviceID), -1)
-	subject = strings.Replace(subject, "$sensor", r.Sensor, -1)
+	return sendEmail([]string{r.Email}, rendered.EmailSubject, rendered.EmailBody)
+}
 
-	msg = strings.Replace(msg, "$value", fmt.Sprintf("%v", lastData[r.Sensor]), -1)
-	msg = strings.Replace(msg, "$collected_at", fmt.Sprintf("%v", lastData["collected_at"]), -1)
-	msg = strings.Replace(msg, "$device_id", fmt.Sprintf("%v", r.DeviceID), -1)
-	msg = strings.Replace(msg, "$sensor", r.Sensor, -1)
+// sendEmail sends an html email with the SMTP server of the api
+func sendEmail(to []string, subject string, msg string) error {
 
 	// Sender data
 	from := os.Getenv("EMAIL")
@@ -146,57 +251,44 @@ func (r *Rule) SendNotificationEmail(lastData map[string]interface{}) {
 	body.Write([]byte(fmt.Sprintf("Subject: "+subject+" \n%s\n\n"+msg, mimeHeaders)))
 
 	// Sending email.
-	smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, body.Bytes())
+	return smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, body.Bytes())
 }
 
-func (r *Rule) sendRequestNotification(lastData map[string]interface{}) error {
-
-	// json payload
-	// replace values and collected_at if exists
-	payload, _ := r.EndpointPayload.Value()
-	stringPayload := fmt.Sprintf("%v", payload)
-
-	newPayload := strings.Replace(stringPayload, "$value", fmt.Sprintf("%v", lastData[r.Sensor]), -1)
-	newPayload = strings.Replace(newPayload, "$collected_at", fmt.Sprintf("%v", lastData["collected_at"]), -1)
-	newPayload = strings.Replace(newPayload, "$device_id", fmt.Sprintf("%v", r.DeviceID), -1)
-	newPayload = strings.Replace(newPayload, "$sensor", r.Sensor, -1)
-
-	json.Unmarshal([]byte(newPayload), &r.EndpointPayload)
-
-	// get the new payload
-	json_data, _ := json.Marshal(r.EndpointPayload)
-
-	client := http.Client{}
-	req, err := http.NewRequest("POST", r.EndpointUrl, bytes.NewBuffer(json_data))
+// sendRequestNotification adds the webhook request to the outbox, the delivery
+// worker sends it and retries it when the endpoint fails
+func (r *Rule) sendRequestNotification(db *gorm.DB, event *RuleEvent, ctx *TemplateContext) error {
 
+	// render the templates of the payload values
+	rendered, err := r.RenderTemplates(ctx)
 	if err != nil {
 		return err
 	}
 
-	// headers
-	headers, _ := json.Marshal(r.EndpointHeader)
-
-	var h map[string]interface{}
-	_ = json.Unmarshal(headers, &h)
+	// get the new payload
+	json_data, _ := json.Marshal(rendered.EndpointPayload)
 
-	for key, value := range h {
-		req.Header.Set(key, fmt.Sprintf("%v", value))
+	delivery := Delivery{
+		Url:      r.EndpointUrl,
+		Headers:  r.EndpointHeader,
+		Payload:  string(json_data),
+		RuleID:   r.ID,
+		TenantID: r.TenantID,
 	}
 
-	// send request
-	_, errRequest := client.Do(req)
-	if errRequest != nil {
-		return errRequest
+	if event != nil {
+		delivery.RuleEventID = &event.ID
 	}
 
-	return nil
+	_, err = delivery.SaveDelivery(db)
+	return err
 }
 
 func (r *Rule) RuleValidations(db *gorm.DB, tenant_id uuid.UUID) formaterror.GeneralError {
 
 	var errors formaterror.GeneralError
 
-	if r.Value == "" {
+	// the conditions have their own values and no_data rules only watch the window
+	if r.Value == "" && len(r.Conditions) == 0 && strings.ToLower(strings.TrimSpace(r.Type)) != RuleTypeNoData {
 		errors.Errors = append(errors.Errors, "value is required")
 
 	}
@@ -227,31 +319,97 @@ func (r *Rule) RuleValidations(db *gorm.DB, tenant_id uuid.UUID) formaterror.Gen
 		}
 	}
 
+	// notification templates
+	errors.Errors = append(errors.Errors, r.templateValidations()...)
+
+	// the schedule limits the hours of the week when the rule is evaluated
+	schedule, errSchedule := r.schedule()
+	if errSchedule != nil {
+		errors.Errors = append(errors.Errors, errSchedule.Error())
+	} else if schedule != nil {
+		errors.Errors = append(errors.Errors, schedule.validate()...)
+	}
+
+	// rules with conditions compare several sensors instead of the rule sensor
+	tree, errTree := r.conditionTree()
+	if errTree != nil {
+		errors.Errors = append(errors.Errors, errTree.Error())
+	}
+
 	// validate sensor name
 	var sensor Sensor
-	if !sensor.IsValidSensorName(db, r.Sensor, r.DeviceID) {
+	if len(r.Conditions) == 0 && r.targetsDevice() && !sensor.IsValidSensorName(db, r.Sensor, r.targetDeviceID()) {
 		errors.Errors = append(errors.Errors, "invalid sensor name")
 	}
 
-	// validate device id
-	if r.DeviceID.String() == "" {
-		errors.Errors = append(errors.Errors, "device_id is required")
+	// validate channels
+	var channel NotificationChannel
+	for _, channel_id := range r.ChannelIDs {
+		if isValid, _ := channel.IsValidNotificationChannel(db, tenant_id, channel_id); !isValid {
+			errors.Errors = append(errors.Errors, "invalid channel_id "+channel_id.String())
+		}
 	}
-	var device Device
-	if !device.IsValidDevice(db, r.DeviceID, tenant_id) {
-		errors.Errors = append(errors.Errors, "invalid device_id")
+
+	// validate escalation policy
+	var policy EscalationPolicy
+	if r.EscalationPolicyID != nil {
+		if isValid, _ := policy.IsValidEscalationPolicy(db, tenant_id, *r.EscalationPolicyID); !isValid {
+			errors.Errors = append(errors.Errors, "invalid escalation_policy_id")
+		}
 	}
 
+	// validate device id or the group of devices
+	errors.Errors = append(errors.Errors, r.targetValidations(db, tenant_id)...)
+
 	if r.TimeBetweenNotification != "" {
 		if !isValidTimeBetweenNotification(r.TimeBetweenNotification) {
 			errors.Errors = append(errors.Errors, "invalid time_between_notification")
 		}
 	}
 
-	if r.CountLatest < 1 {
+	if r.CommandTTL != "" {
+		if _, err := durationFromString(r.CommandTTL); err != nil {
+			errors.Errors = append(errors.Errors, "invalid command_ttl")
+		}
+	}
+
+	if r.For != "" {
+		if _, err := durationFromString(r.For); err != nil {
+			errors.Errors = append(errors.Errors, "invalid for")
+		}
+	}
+	if r.Window != "" {
+		if _, err := durationFromString(r.Window); err != nil {
+			errors.Errors = append(errors.Errors, "invalid window")
+		}
+	}
+	if r.MinSamples < 0 {
+		errors.Errors = append(errors.Errors, "min_samples can not be negative")
+	}
+	if r.Hysteresis < 0 {
+		errors.Errors = append(errors.Errors, "hysteresis can not be negative")
+	}
+
+	if r.Type != "" && r.Type != RuleTypeThreshold && r.Type != RuleTypeNoData {
+		errors.Errors = append(errors.Errors, "invalid type. The available types are: threshold and no_data")
+	}
+
+	if r.Type == RuleTypeNoData {
+		// the window is how long the sensor can be silent before the rule fires
+		if r.Window == "" {
+			errors.Errors = append(errors.Errors, "window is required")
+		}
+		if len(r.Conditions) > 0 {
+			errors.Errors = append(errors.Errors, "no_data rules can not have conditions")
+		}
+
+	} else if tree != nil {
+		errors.Errors = append(errors.Errors, tree.validate(db, r.targetDeviceID(), "conditions", 1)...)
+
+	} else if r.Window == "" && r.CountLatest < 1 {
 		errors.Errors = append(errors.Errors, "count_latest is required")
 
-	} else if r.CountLatest > 1 {
+	} else if r.Window != "" || r.CountLatest > 1 {
 		if r.Operator == "" {
 			errors.Errors = append(errors.Errors, "operator is required")
 		}
@@ -261,8 +419,11 @@ func (r *Rule) RuleValidations(db *gorm.DB, tenant_id uuid.UUID) formaterror.Gen
 		if r.Operation == "" {
 			errors.Errors = append(errors.Errors, "operation is required")
 		}
-		if r.Operation != "sum" && r.Operation != "mean" && r.Operation != "median" && r.Operation != "max" && r.Operation != "min" {
-			errors.Errors = append(errors.Errors, "invalid operation. The available operations are: sum, mean, median, max and min")
+		if r.Operation == "percentile" && (r.Percentile <= 0 || r.Percentile > 100) {
+			errors.Errors = append(errors.Errors, "percentile must be between 0 and 100")
+		}
+		if !isValidOperation(r.Operation) {
+			errors.Errors = append(errors.Errors, "invalid operation. The available operations are: "+availableOperations())
 		}
 
 	} else {
@@ -272,8 +433,8 @@ func (r *Rule) RuleValidations(db *gorm.DB, tenant_id uuid.UUID) formaterror.Gen
 			}
 		}
 		if r.Operation != "" {
-			if r.Operation != "sum" && r.Operation != "mean" && r.Operation != "median" && r.Operation != "max" && r.Operation != "min" {
-				errors.Errors = append(errors.Errors, "invalid operation. The available operations are: sum, mean, median, max and min")
+			if !isValidOperation(r.Operation) {
+				errors.Errors = append(errors.Errors, "invalid operation. The available operations are: "+availableOperations())
 			}
 		}
 	}
@@ -308,6 +469,13 @@ func (r *Rule) SaveRule(db *gorm.DB, tenant_id uuid.UUID) (*Rule, error) {
 		return nil, err
 	}
 
+	if r.ChannelIDs != nil {
+		if err := r.saveChannels(db); err != nil {
+			return nil, err
+		}
+	}
+	r.loadChannelIDs(db)
+
 	return r, nil
 }
 
@@ -334,6 +502,8 @@ func (r *Rule) FindAllRules(db *gorm.DB, tenant_id string, req *http.Request) (i
 		return nil, err
 	}
 
+	loadChannelIDs(db, rules)
+
 	return pagination.ListPaginationSerializer(limit, page, count, totalPages, nextPage, previousPage, rules), nil
 }
 
@@ -346,10 +516,13 @@ func (r *Rule) GetRule(db *gorm.DB, rule_id string) (*Rule, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	rule.loadChannelIDs(db)
 	return &rule, nil
 }
 
-func (r *Rule) UpdateRule(db *gorm.DB, rule_id string) (*Rule, error) {
+// UpdateRule returns the signing secret only when the update created it
+func (r *Rule) UpdateRule(db *gorm.DB, rule_id string) (*RuleWithSecret, error) {
 
 	var err error = db.Model(&Rule{}).Where("id = ?", rule_id).Updates(&r).Error
 
@@ -357,11 +530,60 @@ func (r *Rule) UpdateRule(db *gorm.DB, rule_id string) (*Rule, error) {
 		return nil, err
 	}
 
+	// group rules do not have a device
+	if !r.targetsDevice() {
+		var errDevice error = db.Model(&Rule{}).Where("id = ?", rule_id).UpdateColumn("device_id", gorm.Expr("NULL")).Error
+		if errDevice != nil {
+			return nil, errDevice
+		}
+	}
+
+	// channels are only replaced when sent
+	channelIDs := r.ChannelIDs
+
 	// get the updated rule
 	var err_get_rule error = db.Model(&Rule{}).Where("id = ?", rule_id).Take(&r).Error
 	if err_get_rule != nil {
 		return nil, err_get_rule
 	}
+
+	if channelIDs != nil {
+		r.ChannelIDs = channelIDs
+		if err := r.saveChannels(db); err != nil {
+			return nil, err
+		}
+	}
+
+	// rules that got an endpoint after they were created need a signing secret
+	if r.EndpointUrl != "" && r.SigningSecret == "" {
+		rotated, err := r.RotateSigningSecret(db, rule_id)
+		if err != nil {
+			return nil, err
+		}
+		return &RuleWithSecret{Rule: rotated, SigningSecret: rotated.SigningSecret}, nil
+	}
+
+	r.loadChannelIDs(db)
+	return &RuleWithSecret{Rule: r}, nil
+}
+
+// RotateSigningSecret replaces the secret used to sign the webhook requests of the rule
+func (r *Rule) RotateSigningSecret(db *gorm.DB, rule_id string) (*Rule, error) {
+
+	var err error = db.Model(&Rule{}).Where("id = ?", rule_id).Updates(map[string]interface{}{
+		"signing_secret": randStr(32),
+		"updated_at":     time.Now(),
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var err_get_rule error = db.Model(&Rule{}).Where("id = ?", rule_id).Take(&r).Error
+	if err_get_rule != nil {
+		return nil, err_get_rule
+	}
+
+	r.loadChannelIDs(db)
 	return r, nil
 }
 
@@ -377,7 +599,15 @@ func (e *Rule) DeleteRule(db *gorm.DB, rule_id string) error {
 
 func CheckRule(dbm *mongo.Client, db *gorm.DB, device_id uuid.UUID, lastData map[string]interface{}) {
 
-	var rules []Rule
+	rules := findDeviceRules(db, device_id)
+
+	checkRules(dbm, db, device_id, rules, lastData)
+}
+
+// checkRules evaluates the rules of the device with its latest data. The rules are
+// updated in place, so a cached slice keeps the last notification times.
+func checkRules(dbm *mongo.Client, db *gorm.DB, device_id uuid.UUID, rules []Rule, lastData map[string]interface{}) {
+
 	var sensorsLastData []string
 
 	for key, _ := range lastData {
@@ -386,331 +616,293 @@ func CheckRule(dbm *mongo.Client, db *gorm.DB, device_id uuid.UUID, lastData map
 		}
 	}
 
-	db.Where("device_id = ?", device_id).Find(&rules)
+	now := time.Now()
 
 	for i := 0; i < len(rules); i++ {
+		if rules[i].Status != "active" || !rules[i].isScheduledAt(now) {
+			continue
+		}
+
+		// no data rules fire from the scheduler, new data of the sensor resolves them
+		if rules[i].Type == RuleTypeNoData {
+			if _, ok := lastData[rules[i].Sensor]; ok {
+				rules[i].updateAlert(db, device_id, false, fmt.Sprintf("%v", lastData["collected_at"]), lastData)
+			}
+			continue
+		}
+
+		// rules with a condition tree are evaluated once when any of its sensors is received
+		if len(rules[i].Conditions) > 0 {
+			if rules[i].watchesAnySensor(sensorsLastData) {
+				conditionTreeValue(dbm, db, &rules[i], device_id, lastData)
+			}
+			continue
+		}
+
 		for j := 0; j < len(sensorsLastData); j++ {
-			if rules[i].Sensor == sensorsLastData[j] && rules[i].Status == "active" {
+			if rules[i].Sensor == sensorsLastData[j] {
 
 				// only one device value
-				if rules[i].CountLatest < 2 {
-					onlyOneDeviceValue(dbm, db, rules[i], device_id, lastData)
+				if rules[i].Window == "" && rules[i].CountLatest < 2 {
+					onlyOneDeviceValue(dbm, db, &rules[i], device_id, lastData)
 
-					// N latest device data
-				} else if rules[i].CountLatest > 1 {
-					latestDeviceData(dbm, db, rules[i], device_id, lastData)
+					// N latest device data or the data inside the window
+				} else {
+					latestDeviceData(dbm, db, &rules[i], device_id, lastData)
 				}
 			}
 		}
 	}
 }
 
-func onlyOneDeviceValue(dbm *mongo.Client, db *gorm.DB, rule Rule, device_id uuid.UUID, lastData map[string]interface{}) error {
+func onlyOneDeviceValue(dbm *mongo.Client, db *gorm.DB, rule *Rule, device_id uuid.UUID, lastData map[string]interface{}) error {
 
-	// check notification time
-	if !freeNotificationTime(rule.TimeBetweenNotification, rule.LastNotification) {
-		return nil
+	value, ok, err := sensorValue(dbm, device_id, Condition{Sensor: rule.Sensor, MinSamples: rule.MinSamples})
+	if err != nil || !ok {
+		return err
 	}
 
-	// filter params
-	var opt options.FindOptions
-	opt.SetLimit(1)
-	opt.SetSort(bson.M{"$natural": -1})
+	return rule.processAlert(db, device_id, value, lastData)
+}
 
-	// set filters to mongodb
-	filter := bson.D{{rule.Sensor, bson.D{{"$exists", true}}}}
+func latestDeviceData(dbm *mongo.Client, db *gorm.DB, rule *Rule, device_id uuid.UUID, lastData map[string]interface{}) error {
 
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	collection := dbm.Database("siot").Collection(fmt.Sprintf("%v", device_id))
-	cur, err := collection.Find(ctx, filter, &opt)
-	if err != nil {
+	value, ok, err := sensorValue(dbm, device_id, rule.leafCondition())
+	if err != nil || !ok {
 		return err
 	}
 
-	var data Data
-	if err = cur.All(ctx, &data.Data); err != nil {
-		return errors.New("error returning data")
-	}
-
-	if len(data.Data) > 0 {
-
-		if rule.Operator == "gt" {
-			if ruleValue, err := strconv.ParseFloat(rule.Value, 64); err == nil {
-				if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", data.Data[0][rule.Sensor]), 64); err == nil {
-					if deviceValue > ruleValue {
-						rule.updateNotificationTime(db)
-						if rule.Email != "" {
-							rule.SendNotificationEmail(lastData)
-						}
-						if rule.EndpointUrl != "" {
-							rule.sendRequestNotification(lastData)
-						}
-					}
-				}
-			}
+	return rule.processAlert(db, device_id, value, lastData)
+}
 
-		} else if rule.Operator == "gte" {
-			if ruleValue, err := strconv.ParseFloat(rule.Value, 64); err == nil {
-				if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", data.Data[0][rule.Sensor]), 64); err == nil {
-					if deviceValue >= ruleValue {
-						rule.updateNotificationTime(db)
-						if rule.Email != "" {
-							rule.SendNotificationEmail(lastData)
-						}
-						if rule.EndpointUrl != "" {
-							rule.sendRequestNotification(lastData)
-						}
-					}
-				}
-			}
-		} else if rule.Operator == "lt" {
-			if ruleValue, err := strconv.ParseFloat(rule.Value, 64); err == nil {
-				if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", data.Data[0][rule.Sensor]), 64); err == nil {
-					if deviceValue < ruleValue {
-						rule.updateNotificationTime(db)
-						if rule.Email != "" {
-							rule.SendNotificationEmail(lastData)
-						}
-						if rule.EndpointUrl != "" {
-							rule.sendRequestNotification(lastData)
-						}
-					}
-				}
-			}
-		} else if rule.Operator == "lte" {
-			if ruleValue, err := strconv.ParseFloat(rule.Value, 64); err == nil {
-				if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", data.Data[0][rule.Sensor]), 64); err == nil {
-					if deviceValue <= ruleValue {
-						rule.updateNotificationTime(db)
-						if rule.Email != "" {
-							rule.SendNotificationEmail(lastData)
-						}
-						if rule.EndpointUrl != "" {
-							rule.sendRequestNotification(lastData)
-						}
-					}
-				}
-			}
-		} else {
-			if ruleValue, err := strconv.ParseFloat(rule.Value, 64); err == nil {
-				if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", data.Data[0][rule.Sensor]), 64); err == nil {
-					if deviceValue == ruleValue {
-						rule.updateNotificationTime(db)
-						if rule.Email != "" {
-							rule.SendNotificationEmail(lastData)
-						}
-						if rule.EndpointUrl != "" {
-							rule.sendRequestNotification(lastData)
-						}
-					}
-				} else {
-					if fmt.Sprintf("%v", data.Data[0][rule.Sensor]) == rule.Value {
-						rule.updateNotificationTime(db)
-						if rule.Email != "" {
-							rule.SendNotificationEmail(lastData)
-						}
-						if rule.EndpointUrl != "" {
-							rule.sendRequestNotification(lastData)
-						}
-					}
-				}
-			} else {
-				if fmt.Sprintf("%v", data.Data[0][rule.Sensor]) == rule.Value {
-					rule.updateNotificationTime(db)
-					if rule.Email != "" {
-						rule.SendNotificationEmail(lastData)
-					}
-					if rule.EndpointUrl != "" {
-						rule.sendRequestNotification(lastData)
-					}
-				}
-			}
+func conditionTreeValue(dbm *mongo.Client, db *gorm.DB, rule *Rule, device_id uuid.UUID, lastData map[string]interface{}) error {
 
-		}
+	tree, err := rule.conditionTree()
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
+	values := map[string]interface{}{}
+	holds, err := tree.evaluate(func(c Condition) (interface{}, bool, error) {
+		return sensorValue(dbm, device_id, c)
+	}, values)
+	if err != nil {
+		return err
+	}
 
-func latestDeviceData(dbm *mongo.Client, db *gorm.DB, rule Rule, device_id uuid.UUID, lastData map[string]interface{}) error {
+	return rule.updateAlert(db, device_id, holds, formatConditionValues(values), lastData)
+}
 
-	// check notification time
-	if !freeNotificationTime(rule.TimeBetweenNotification, rule.LastNotification) {
-		return nil
+// leafCondition is the condition of a single sensor rule
+func (r *Rule) leafCondition() Condition {
+	return Condition{
+		Sensor:      r.Sensor,
+		Operator:    r.Operator,
+		Value:       r.Value,
+		Operation:   r.Operation,
+		CountLatest: r.CountLatest,
+		Window:      r.Window,
+		MinSamples:  r.MinSamples,
+		Percentile:  r.Percentile,
 	}
+}
+
+// sensorValue returns the latest value of the sensor of the condition or the
+// operation over the count latest values or over the values collected inside the
+// window. ok is false when there is no data or less than the minimum samples.
+func sensorValue(dbm *mongo.Client, device_id uuid.UUID, c Condition) (interface{}, bool, error) {
 
 	// filter params
 	var opt options.FindOptions
-	opt.SetLimit(rule.CountLatest)
 	opt.SetSort(bson.M{"$natural": -1})
 
 	// set filters to mongodb
-	filter := bson.D{{rule.Sensor, bson.D{{"$exists", true}}}}
+	filter := bson.M{c.Sensor: bson.M{"$exists": true}}
+
+	if c.Window != "" {
+		window, err := durationFromString(c.Window)
+		if err != nil {
+			return nil, false, err
+		}
+		filter["collected_at"] = bson.M{"$gte": time.Now().UTC().Add(-window).Format("2006-01-02T15:04:05.000Z")}
 
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	} else if c.CountLatest > 1 {
+		opt.SetLimit(c.CountLatest)
+
+	} else {
+		opt.SetLimit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	collection := dbm.Database("siot").Collection(fmt.Sprintf("%v", device_id))
 	cur, err := collection.Find(ctx, filter, &opt)
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
 	var data Data
 	if err = cur.All(ctx, &data.Data); err != nil {
-		return errors.New("error returning data")
+		return nil, false, errors.New("error returning data")
 	}
 
-	if len(data.Data) > 0 {
+	value, ok := conditionValue(c, data.Data, time.Now().UTC())
+	return value, ok, nil
+}
 
-		var calculatedValue float64
+// conditionValue computes the value of the condition at the given time from the
+// device data sorted from the newest to the oldest
+func conditionValue(c Condition, data []map[string]interface{}, now time.Time) (interface{}, bool) {
 
-		// operation
-		// sum
-		if rule.Operation == "sum" {
+	since := ""
+	if c.Window != "" {
+		window, _ := durationFromString(c.Window)
+		since = now.Add(-window).Format("2006-01-02T15:04:05.000Z")
+	}
 
-			for _, value := range data.Data {
+	limit := c.CountLatest
+	if c.Window != "" || limit < 1 {
+		limit = 1
+	}
 
-				if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", value[rule.Sensor]), 64); err == nil {
-					calculatedValue = calculatedValue + deviceValue
-				}
+	var samples []map[string]interface{}
+	for _, value := range data {
+		if _, ok := value[c.Sensor]; !ok {
+			continue
+		}
 
+		if c.Window != "" {
+			if fmt.Sprintf("%v", value["collected_at"]) < since {
+				break
 			}
+		} else if int64(len(samples)) >= limit {
+			break
+		}
 
-		} else if rule.Operation == "mean" {
+		samples = append(samples, value)
+	}
 
-			var auxValue float64
+	if len(samples) == 0 || int64(len(samples)) < c.MinSamples {
+		return nil, false
+	}
 
-			for _, value := range data.Data {
-				if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", value[rule.Sensor]), 64); err == nil {
-					auxValue = auxValue + deviceValue
-				}
-			}
+	if c.Window == "" && c.CountLatest < 2 {
+		return samples[0][c.Sensor], true
+	}
 
-			calculatedValue = auxValue / float64(len(data.Data))
+	return calculateOperation(c, samples), true
+}
 
-		} else if rule.Operation == "median" {
-			var auxValues []float64
+// calculateOperation applies the rule operation to the values of the sensor
+func calculateOperation(c Condition, data []map[string]interface{}) float64 {
 
-			for _, value := range data.Data {
-				if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", value[rule.Sensor]), 64); err == nil {
-					auxValues = append(auxValues, deviceValue)
-				}
-			}
+	operation := c.Operation
+	sensor := c.Sensor
 
-			// sort list of values
-			sort.Float64s(auxValues)
+	var calculatedValue float64
 
-			mNumber := len(auxValues) / 2
+	// operation
+	// sum
+	if operation == "sum" {
 
-			// is even
-			if len(auxValues)%2 == 0 {
-				calculatedValue = (auxValues[mNumber-1] + auxValues[mNumber]) / 2
+		for _, value := range data {
 
-				// is odd
-			} else {
-				calculatedValue = auxValues[mNumber]
+			if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", value[sensor]), 64); err == nil {
+				calculatedValue = calculatedValue + deviceValue
 			}
 
-		} else if rule.Operation == "max" {
-			var auxValue float64
+		}
 
-			for _, value := range data.Data {
-				if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", value[rule.Sensor]), 64); err == nil {
-					if deviceValue > auxValue {
-						auxValue = deviceValue
-					}
-				}
-			}
+	} else if operation == "mean" {
 
-			calculatedValue = auxValue
+		var auxValue float64
 
-		} else if rule.Operation == "min" {
-			var auxValue float64
+		for _, value := range data {
+			if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", value[sensor]), 64); err == nil {
+				auxValue = auxValue + deviceValue
+			}
+		}
 
-			for _, value := range data.Data {
-				if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", value[rule.Sensor]), 64); err == nil {
-					if auxValue == 0 {
-						auxValue = deviceValue
+		calculatedValue = auxValue / float64(len(data))
 
-					} else {
-						if deviceValue < auxValue {
-							auxValue = deviceValue
-						}
-					}
-				}
+	} else if operation == "median" {
+		var auxValues []float64
+
+		for _, value := range data {
+			if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", value[sensor]), 64); err == nil {
+				auxValues = append(auxValues, deviceValue)
 			}
+		}
+
+		// sort list of values
+		sort.Float64s(auxValues)
+
+		mNumber := len(auxValues) / 2
 
-			calculatedValue = auxValue
+		if len(auxValues) == 0 {
+			calculatedValue = 0
 
+			// is even
+		} else if len(auxValues)%2 == 0 {
+			calculatedValue = (auxValues[mNumber-1] + auxValues[mNumber]) / 2
+
+			// is odd
+		} else {
+			calculatedValue = auxValues[mNumber]
 		}
 
-		// operator
-		if rule.Operator == "gt" {
-			if ruleValue, err := strconv.ParseFloat(rule.Value, 64); err == nil {
-				if calculatedValue > ruleValue {
-					rule.updateNotificationTime(db)
-					if rule.Email != "" {
-						rule.SendNotificationEmail(lastData)
-					}
-					if rule.EndpointUrl != "" {
-						rule.sendRequestNotification(lastData)
-					}
-				}
-			}
+	} else if operation == "max" {
+		var auxValue float64
 
-		} else if rule.Operator == "gte" {
-			if ruleValue, err := strconv.ParseFloat(rule.Value, 64); err == nil {
-				if calculatedValue >= ruleValue {
-					rule.updateNotificationTime(db)
-					if rule.Email != "" {
-						rule.SendNotificationEmail(lastData)
-					}
-					if rule.EndpointUrl != "" {
-						rule.sendRequestNotification(lastData)
-					}
+		for _, value := range data {
+			if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", value[sensor]), 64); err == nil {
+				if deviceValue > auxValue {
+					auxValue = deviceValue
 				}
 			}
-		} else if rule.Operator == "lt" {
-			if ruleValue, err := strconv.ParseFloat(rule.Value, 64); err == nil {
-				if calculatedValue < ruleValue {
-					rule.updateNotificationTime(db)
-					if rule.Email != "" {
-						rule.SendNotificationEmail(lastData)
-					}
-					if rule.EndpointUrl != "" {
-						rule.sendRequestNotification(lastData)
-					}
-				}
-			}
-		} else if rule.Operator == "lte" {
-			if ruleValue, err := strconv.ParseFloat(rule.Value, 64); err == nil {
-				if calculatedValue <= ruleValue {
-					rule.updateNotificationTime(db)
-					if rule.Email != "" {
-						rule.SendNotificationEmail(lastData)
-					}
-					if rule.EndpointUrl != "" {
-						rule.sendRequestNotification(lastData)
-					}
-				}
-			}
-		} else {
-			if ruleValue, err := strconv.ParseFloat(rule.Value, 64); err == nil {
-				if calculatedValue == ruleValue {
-					rule.updateNotificationTime(db)
-					if rule.Email != "" {
-						rule.SendNotificationEmail(lastData)
-					}
-					if rule.EndpointUrl != "" {
-						rule.sendRequestNotification(lastData)
+		}
+
+		calculatedValue = auxValue
+
+	} else if operation == "min" {
+		var auxValue float64
+
+		for _, value := range data {
+			if deviceValue, err := strconv.ParseFloat(fmt.Sprintf("%v", value[sensor]), 64); err == nil {
+				if auxValue == 0 {
+					auxValue = deviceValue
+
+				} else {
+					if deviceValue < auxValue {
+						auxValue = deviceValue
 					}
 				}
 			}
-
 		}
 
+		calculatedValue = auxValue
+
+	} else if operation == "delta" {
+		calculatedValue = deltaValue(sensor, data)
+
+	} else if operation == "rate" {
+		calculatedValue = rateValue(sensor, data, time.Second)
+
+	} else if operation == "rate_per_minute" {
+		calculatedValue = rateValue(sensor, data, time.Minute)
+
+	} else if operation == "stddev" {
+		calculatedValue = stddevValue(numericValues(sensor, data))
+
+	} else if operation == "percentile" {
+		calculatedValue = percentileValue(numericValues(sensor, data), c.Percentile)
+
+	} else if operation == "count" {
+		calculatedValue = float64(len(data))
+
 	}
 
-	return nil
+	return calculatedValue
 }
 
 func isValidTimeBetweenNotification(timeBetween string) bool {
@@ -775,3 +967,42 @@ func freeNotificationTime(timeBetween string, lastNotification time.Time) bool {
 
 	return false
 }
+
+// durationFromString converts values like 30s, 10m, 2h or 1d into a duration
+func durationFromString(value string) (time.Duration, error) {
+
+	if len(value) < 2 {
+		return 0, errors.New("invalid duration")
+	}
+
+	amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || amount < 0 {
+		return 0, errors.New("invalid duration")
+	}
+
+	switch value[len(value)-1:] {
+	case "s":
+		return time.Duration(amount) * time.Second, nil
+	case "m":
+		return time.Duration(amount) * time.Minute, nil
+	case "h":
+		return time.Duration(amount) * time.Hour, nil
+	case "d":
+		return time.Duration(amount) * 24 * time.Hour, nil
+	}
+
+	return 0, errors.New("invalid duration")
+}
+
+// formatDuration writes durations like 30m or 1h30m instead of 30m0s or 1h30m0s
+func formatDuration(d time.Duration) string {
+
+	value := d.String()
+	if strings.HasSuffix(value, "h0s") || strings.HasSuffix(value, "m0s") {
+		value = strings.TrimSuffix(value, "0s")
+	}
+	if strings.HasSuffix(value, "h0m") {
+		value = strings.TrimSuffix(value, "0m")
+	}
+	return value
+}
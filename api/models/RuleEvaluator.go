@@ -0,0 +1,229 @@
+package models
+
+import (
+	"errors"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrEvaluationQueueFull = errors.New("rule evaluation queue is full")
+	ErrEvaluatorStopped    = errors.New("rule evaluator is stopped")
+)
+
+type ruleJob struct {
+	deviceID uuid.UUID
+	lastData map[string]interface{}
+}
+
+type cachedRules struct {
+	rules     []Rule
+	expiresAt time.Time
+}
+
+// EvaluatorMetrics are the counters of the rule evaluator
+type EvaluatorMetrics struct {
+	Workers       int   `json:"workers"`
+	QueueCapacity int   `json:"queue_capacity"`
+	QueueLength   int   `json:"queue_length"`
+	Enqueued      int64 `json:"enqueued"`
+	Processed     int64 `json:"processed"`
+	Dropped       int64 `json:"dropped"`
+	CacheHits     int64 `json:"cache_hits"`
+	CacheMisses   int64 `json:"cache_misses"`
+}
+
+// RuleEvaluator checks the rules of the received data with a fixed number of
+// workers. The data of a device always goes to the same worker, so it is
+// evaluated in the order it was received.
+type RuleEvaluator struct {
+	// counters first, they are updated atomically and must be 64-bit aligned
+	enqueued    int64
+	processed   int64
+	dropped     int64
+	cacheHits   int64
+	cacheMisses int64
+
+	DB  *gorm.DB
+	MDB *mongo.Client
+
+	queues       []chan ruleJob
+	queueTimeout time.Duration
+	cacheTTL     time.Duration
+	evaluate     func(job ruleJob)
+
+	mu      sync.RWMutex
+	stopped bool
+	wg      sync.WaitGroup
+
+	cacheMu sync.Mutex
+	cache   map[uuid.UUID]*cachedRules
+}
+
+func envInt(name string, fallback int) int {
+
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 1 {
+		return fallback
+	}
+	return value
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+
+	value, err := durationFromString(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// NewRuleEvaluator starts the workers. The size of the queue is shared by them.
+func NewRuleEvaluator(dbm *mongo.Client, db *gorm.DB) *RuleEvaluator {
+
+	e := &RuleEvaluator{
+		DB:           db,
+		MDB:          dbm,
+		queueTimeout: envDuration("RULE_QUEUE_TIMEOUT", time.Second),
+		cacheTTL:     envDuration("RULE_CACHE_TTL", 30*time.Second),
+		cache:        map[uuid.UUID]*cachedRules{},
+	}
+	e.evaluate = e.checkRules
+
+	e.start(envInt("RULE_WORKERS", 4), envInt("RULE_QUEUE_SIZE", 1000))
+	return e
+}
+
+// start runs the workers, each one with its part of the queue size
+func (e *RuleEvaluator) start(workers int, queueSize int) {
+
+	perWorker := queueSize / workers
+	if perWorker < 1 {
+		perWorker = 1
+	}
+
+	e.queues = make([]chan ruleJob, workers)
+	for i := 0; i < workers; i++ {
+		e.queues[i] = make(chan ruleJob, perWorker)
+		e.wg.Add(1)
+		go e.work(e.queues[i])
+	}
+}
+
+func (e *RuleEvaluator) work(queue chan ruleJob) {
+
+	defer e.wg.Done()
+
+	for job := range queue {
+		e.evaluate(job)
+		atomic.AddInt64(&e.processed, 1)
+	}
+}
+
+// checkRules evaluates the cached rules of the device with its latest data
+func (e *RuleEvaluator) checkRules(job ruleJob) {
+	checkRules(e.MDB, e.DB, job.deviceID, e.deviceRules(job.deviceID), job.lastData)
+}
+
+// Enqueue adds the latest data of the device to the queue of its worker. When the
+// queue is still full after the queue timeout the evaluation is dropped.
+func (e *RuleEvaluator) Enqueue(device_id uuid.UUID, lastData map[string]interface{}) error {
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.stopped {
+		return ErrEvaluatorStopped
+	}
+
+	h := fnv.New32a()
+	h.Write(device_id[:])
+	queue := e.queues[h.Sum32()%uint32(len(e.queues))]
+
+	timer := time.NewTimer(e.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case queue <- ruleJob{deviceID: device_id, lastData: lastData}:
+		atomic.AddInt64(&e.enqueued, 1)
+		return nil
+	case <-timer.C:
+		atomic.AddInt64(&e.dropped, 1)
+		return ErrEvaluationQueueFull
+	}
+}
+
+// Stop stops accepting data and waits until the queued data is evaluated
+func (e *RuleEvaluator) Stop() {
+
+	e.mu.Lock()
+	if e.stopped {
+		e.mu.Unlock()
+		return
+	}
+	e.stopped = true
+	for _, queue := range e.queues {
+		close(queue)
+	}
+	e.mu.Unlock()
+
+	e.wg.Wait()
+}
+
+// deviceRules returns the rules of the device from the cache
+func (e *RuleEvaluator) deviceRules(device_id uuid.UUID) []Rule {
+
+	e.cacheMu.Lock()
+	cached, ok := e.cache[device_id]
+	e.cacheMu.Unlock()
+
+	if ok && time.Now().Before(cached.expiresAt) {
+		atomic.AddInt64(&e.cacheHits, 1)
+		return cached.rules
+	}
+
+	atomic.AddInt64(&e.cacheMisses, 1)
+
+	rules := findDeviceRules(e.DB, device_id)
+
+	e.cacheMu.Lock()
+	e.cache[device_id] = &cachedRules{rules: rules, expiresAt: time.Now().Add(e.cacheTTL)}
+	e.cacheMu.Unlock()
+
+	return rules
+}
+
+// InvalidateRules empties the cache, it is called when a rule changes
+func (e *RuleEvaluator) InvalidateRules() {
+
+	e.cacheMu.Lock()
+	e.cache = map[uuid.UUID]*cachedRules{}
+	e.cacheMu.Unlock()
+}
+
+func (e *RuleEvaluator) Metrics() EvaluatorMetrics {
+
+	metrics := EvaluatorMetrics{
+		Workers:     len(e.queues),
+		Enqueued:    atomic.LoadInt64(&e.enqueued),
+		Processed:   atomic.LoadInt64(&e.processed),
+		Dropped:     atomic.LoadInt64(&e.dropped),
+		CacheHits:   atomic.LoadInt64(&e.cacheHits),
+		CacheMisses: atomic.LoadInt64(&e.cacheMisses),
+	}
+
+	for _, queue := range e.queues {
+		metrics.QueueCapacity += cap(queue)
+		metrics.QueueLength += len(queue)
+	}
+
+	return metrics
+}
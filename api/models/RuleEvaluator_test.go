@@ -0,0 +1,114 @@
+package models
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestEvaluator runs the workers with evaluate instead of the rules
+func newTestEvaluator(workers int, queueSize int, queueTimeout time.Duration, evaluate func(job ruleJob)) *RuleEvaluator {
+
+	e := &RuleEvaluator{queueTimeout: queueTimeout, evaluate: evaluate}
+	e.start(workers, queueSize)
+	return e
+}
+
+func TestEvaluatorKeepsTheOrderOfADevice(t *testing.T) {
+
+	var mu sync.Mutex
+	received := map[uuid.UUID][]int{}
+
+	e := newTestEvaluator(2, 100, time.Second, func(job ruleJob) {
+		mu.Lock()
+		received[job.deviceID] = append(received[job.deviceID], job.lastData["seq"].(int))
+		mu.Unlock()
+	})
+
+	devices := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for i := 0; i < 50; i++ {
+		for _, device_id := range devices {
+			if err := e.Enqueue(device_id, map[string]interface{}{"seq": i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	e.Stop()
+
+	for _, device_id := range devices {
+		seqs := received[device_id]
+		if len(seqs) != 50 {
+			t.Fatalf("device %v: expected 50 evaluations, got %v", device_id, len(seqs))
+		}
+		for i, seq := range seqs {
+			if seq != i {
+				t.Fatalf("device %v: evaluated out of order %v", device_id, seqs)
+			}
+		}
+	}
+
+	if metrics := e.Metrics(); metrics.Enqueued != 150 || metrics.Processed != 150 || metrics.Dropped != 0 {
+		t.Fatalf("unexpected metrics %+v", metrics)
+	}
+}
+
+func TestEvaluatorDropsWhenTheQueueIsFull(t *testing.T) {
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+
+	e := newTestEvaluator(1, 1, 20*time.Millisecond, func(job ruleJob) {
+		started <- struct{}{}
+		<-release
+	})
+
+	device_id := uuid.New()
+
+	// the worker holds the first one, the queue the second one
+	if err := e.Enqueue(device_id, map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := e.Enqueue(device_id, map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Enqueue(device_id, map[string]interface{}{}); err != ErrEvaluationQueueFull {
+		t.Fatalf("expected %v, got %v", ErrEvaluationQueueFull, err)
+	}
+
+	close(release)
+	e.Stop()
+
+	if metrics := e.Metrics(); metrics.Enqueued != 2 || metrics.Processed != 2 || metrics.Dropped != 1 {
+		t.Fatalf("unexpected metrics %+v", metrics)
+	}
+}
+
+func TestEvaluatorDrainsOnStop(t *testing.T) {
+
+	e := newTestEvaluator(1, 10, time.Second, func(job ruleJob) {
+		time.Sleep(5 * time.Millisecond)
+	})
+
+	for i := 0; i < 10; i++ {
+		if err := e.Enqueue(uuid.New(), map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Stop returns once every queued evaluation is done
+	e.Stop()
+	if processed := e.Metrics().Processed; processed != 10 {
+		t.Fatalf("expected 10 evaluations after Stop, got %v", processed)
+	}
+
+	if err := e.Enqueue(uuid.New(), map[string]interface{}{}); err != ErrEvaluatorStopped {
+		t.Fatalf("expected %v, got %v", ErrEvaluatorStopped, err)
+	}
+
+	// stopping twice does nothing
+	e.Stop()
+}
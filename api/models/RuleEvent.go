@@ -0,0 +1,182 @@
+package models
+
+import (
+	"net/http"
+	"siot/api/utils/pagination"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	RuleEventStatusQueued     = "queued"
+	RuleEventStatusDelivered  = "delivered"
+	RuleEventStatusFailed     = "failed"
+	RuleEventStatusSuppressed = "suppressed" // silenced by a maintenance window
+)
+
+// RuleEvent records every notification sent when a rule is evaluated
+type RuleEvent struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	State     string     `gorm:"size:255;" json:"state"`
+	Value     string     `gorm:"size:255;" json:"value"`
+	Threshold string     `gorm:"size:255;" json:"threshold"`
+	Operator  string     `gorm:"size:255;" json:"operator"`
+	Channel   string     `gorm:"size:255;" json:"channel"`
+	Target    string     `gorm:"size:255;" json:"target"`
+	Status    string     `gorm:"size:255;" json:"status"`
+	Delivered bool       `gorm:"default:false" json:"delivered"`
+	Error     string     `gorm:"type:text;" json:"error"`
+	RuleID    uuid.UUID  `sql:"type:uuid REFERENCES rules(id) ON DELETE CASCADE" json:"rule_id"`
+	DeviceID  uuid.UUID  `sql:"type:uuid REFERENCES devices(id) ON DELETE CASCADE" json:"device_id"`
+	AlertID   *uuid.UUID `sql:"type:uuid" json:"alert_id"`
+	TenantID  uuid.UUID  `sql:"type:uuid REFERENCES tenants(id) ON DELETE CASCADE" json:"-"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (e *RuleEvent) BeforeCreate() {
+
+	e.CreatedAt = time.Now()
+	e.UpdatedAt = time.Now()
+}
+
+// recordEvent stores the result of a notification sent through a channel
+func (r *Rule) recordEvent(db *gorm.DB, alert *Alert, state string, channel string, target string, errSend error) (*RuleEvent, error) {
+
+	event := RuleEvent{
+		State:     state,
+		Value:     alert.Value,
+		Threshold: r.Value,
+		Operator:  r.Operator,
+		Channel:   channel,
+		Target:    target,
+		Status:    RuleEventStatusDelivered,
+		Delivered: errSend == nil,
+		RuleID:    r.ID,
+		DeviceID:  alert.DeviceID,
+		AlertID:   &alert.ID,
+		TenantID:  r.TenantID,
+	}
+
+	if errSend != nil {
+		event.Status = RuleEventStatusFailed
+		event.Error = errSend.Error()
+	}
+
+	var err error = db.Create(&event).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+// recordQueuedEvent stores a notification that is sent later by the delivery worker
+func (r *Rule) recordQueuedEvent(db *gorm.DB, alert *Alert, state string, channel string, target string) (*RuleEvent, error) {
+
+	event := RuleEvent{
+		State:     state,
+		Value:     alert.Value,
+		Threshold: r.Value,
+		Operator:  r.Operator,
+		Channel:   channel,
+		Target:    target,
+		Status:    RuleEventStatusQueued,
+		RuleID:    r.ID,
+		DeviceID:  alert.DeviceID,
+		AlertID:   &alert.ID,
+		TenantID:  r.TenantID,
+	}
+
+	var err error = db.Create(&event).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+// recordSuppressedEvent stores a notification that was not sent because the device
+// is in a maintenance window
+func (r *Rule) recordSuppressedEvent(db *gorm.DB, alert *Alert, state string, window *MaintenanceWindow) (*RuleEvent, error) {
+
+	event := RuleEvent{
+		State:     state,
+		Value:     alert.Value,
+		Threshold: r.Value,
+		Operator:  r.Operator,
+		Channel:   "maintenance",
+		Target:    window.Name,
+		Status:    RuleEventStatusSuppressed,
+		RuleID:    r.ID,
+		DeviceID:  alert.DeviceID,
+		AlertID:   &alert.ID,
+		TenantID:  r.TenantID,
+	}
+
+	var err error = db.Create(&event).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+func (e *RuleEvent) FindAllRuleEvents(db *gorm.DB, tenant_id string, rule_id string, r *http.Request) (interface{}, error) {
+
+	events := []RuleEvent{}
+
+	query := db.Where("tenant_id = ?", tenant_id)
+
+	// filters
+	if rule_id != "" {
+		query = query.Where("rule_id = ?", rule_id)
+	} else if r.URL.Query().Get("rule_id") != "" {
+		query = query.Where("rule_id = ?", r.URL.Query().Get("rule_id"))
+	}
+	if r.URL.Query().Get("device_id") != "" {
+		query = query.Where("device_id = ?", r.URL.Query().Get("device_id"))
+	}
+	if r.URL.Query().Get("channel") != "" {
+		query = query.Where("channel = ?", r.URL.Query().Get("channel"))
+	}
+	if r.URL.Query().Get("status") != "" {
+		query = query.Where("status = ?", r.URL.Query().Get("status"))
+	}
+	if r.URL.Query().Get("delivered") != "" {
+		query = query.Where("delivered = ?", r.URL.Query().Get("delivered") == "true")
+	}
+
+	// filter by date
+	from, to := ValidateFromTo(r)
+	if from != "" {
+		fromDate, _ := time.Parse("2006-01-02T15:04:05.000Z", from)
+		query = query.Where("created_at > ?", fromDate)
+	}
+	if toDate, err := time.Parse("2006-01-02T15:04:05.000Z", to); err == nil {
+		query = query.Where("created_at < ?", toDate)
+	}
+
+	var count int
+
+	var err_count error = query.Find(&events).Count(&count).Error
+	if err_count != nil {
+		return nil, err_count
+	}
+
+	// pagination
+	offset, limit, page, totalPages, nextPage, previousPage, errPagination := pagination.ValidatePagination(r, count)
+	if errPagination != nil {
+		return nil, errPagination
+	}
+
+	// query
+	var err error = query.Limit(limit).Offset(offset).Order("created_at desc").Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return pagination.ListPaginationSerializer(limit, page, count, totalPages, nextPage, previousPage, events), nil
+}
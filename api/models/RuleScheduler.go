@@ -0,0 +1,191 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RuleScheduler periodically evaluates the rules that can not wait for device
+// data, like the no data rules of devices that stopped reporting, and escalates
+// the firing alerts that are not acknowledged
+type RuleScheduler struct {
+	DB       *gorm.DB
+	MDB      *mongo.Client
+	Interval time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewRuleScheduler(dbm *mongo.Client, db *gorm.DB) *RuleScheduler {
+
+	interval, err := durationFromString(os.Getenv("RULE_SCHEDULER_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	return &RuleScheduler{
+		DB:       db,
+		MDB:      dbm,
+		Interval: interval,
+	}
+}
+
+// Start runs the scheduler in the background until Stop is called
+func (s *RuleScheduler) Start() {
+
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.CheckNoDataRules()
+				s.CheckEscalations()
+			}
+		}
+	}()
+}
+
+// Stop waits for the current evaluation to finish
+func (s *RuleScheduler) Stop() {
+
+	if s.stop == nil {
+		return
+	}
+
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// CheckNoDataRules fires the no data rules whose sensor has been silent for longer
+// than the rule window and resolves the ones whose sensor reports again
+func (s *RuleScheduler) CheckNoDataRules() {
+
+	var rules []Rule
+
+	var err error = s.DB.Where("type = ? AND status = ?", RuleTypeNoData, "active").Find(&rules).Error
+	if err != nil {
+		log.Printf("rule scheduler: %v", err)
+		return
+	}
+
+	now := time.Now()
+
+	for i := 0; i < len(rules); i++ {
+		if !rules[i].isScheduledAt(now) {
+			continue
+		}
+
+		for _, device_id := range rules[i].TargetDevices(s.DB) {
+			if err := rules[i].checkNoData(s.MDB, s.DB, device_id); err != nil {
+				log.Printf("rule scheduler: rule %v: device %v: %v", rules[i].ID, device_id, err)
+			}
+		}
+	}
+}
+
+// CheckEscalations notifies the escalation steps that became due for the firing
+// alerts that were not acknowledged
+func (s *RuleScheduler) CheckEscalations() {
+
+	var alerts []Alert
+
+	var err error = s.DB.Joins("JOIN rules ON rules.id = alerts.rule_id").
+		Where("alerts.state = ? AND alerts.acknowledged_at IS NULL AND rules.escalation_policy_id IS NOT NULL AND rules.status = ?", AlertStateFiring, "active").
+		Find(&alerts).Error
+	if err != nil {
+		log.Printf("rule scheduler: %v", err)
+		return
+	}
+
+	for i := 0; i < len(alerts); i++ {
+		rule := Rule{}
+		if s.DB.Where("id = ?", alerts[i].RuleID).Take(&rule).Error != nil {
+			continue
+		}
+
+		if err := rule.escalate(s.DB, &alerts[i]); err != nil {
+			log.Printf("rule scheduler: alert %v: %v", alerts[i].ID, err)
+		}
+	}
+}
+
+func (r *Rule) checkNoData(dbm *mongo.Client, db *gorm.DB, device_id uuid.UUID) error {
+
+	window, err := durationFromString(r.Window)
+	if err != nil {
+		return err
+	}
+
+	lastSeen, ok, err := lastCollectedAt(dbm, device_id, r.Sensor)
+	if err != nil {
+		return err
+	}
+
+	value := "no data"
+	lastData := map[string]interface{}{}
+	silent := !ok || time.Since(lastSeen) > window
+
+	if ok {
+		value = lastSeen.Format("2006-01-02T15:04:05.000Z")
+		lastData["collected_at"] = value
+	}
+
+	return r.updateAlert(db, device_id, silent, value, lastData)
+}
+
+// lastCollectedAt returns when the device last sent a value of the sensor
+func lastCollectedAt(dbm *mongo.Client, device_id uuid.UUID, sensor string) (time.Time, bool, error) {
+
+	// filter params
+	var opt options.FindOptions
+	opt.SetLimit(1)
+	opt.SetSort(bson.M{"$natural": -1})
+
+	// set filters to mongodb
+	filter := bson.M{sensor: bson.M{"$exists": true}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := dbm.Database("siot").Collection(fmt.Sprintf("%v", device_id))
+	cur, err := collection.Find(ctx, filter, &opt)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	var data Data
+	if err = cur.All(ctx, &data.Data); err != nil {
+		return time.Time{}, false, errors.New("error returning data")
+	}
+
+	if len(data.Data) == 0 {
+		return time.Time{}, false, nil
+	}
+
+	collectedAt, err := time.Parse("2006-01-02T15:04:05.000Z", fmt.Sprintf("%v", data.Data[0]["collected_at"]))
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	return collectedAt, true, nil
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"errors"
+	"html"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// targets of rules. A device rule watches its device, the other targets watch
+// every device of the tenant, of a tag or of a profile that has the rule sensors.
+const (
+	RuleTargetDevice  = "device"
+	RuleTargetTenant  = "tenant"
+	RuleTargetTag     = "tag"
+	RuleTargetProfile = "profile"
+)
+
+func (r *Rule) prepareTarget() {
+
+	r.Target = strings.ToLower(strings.TrimSpace(r.Target))
+	r.TargetValue = html.EscapeString(strings.TrimSpace(r.TargetValue))
+}
+
+func (r *Rule) targetsDevice() bool {
+	return r.Target == "" || r.Target == RuleTargetDevice
+}
+
+// targetDeviceID is the device of a device rule, uuid.Nil for the other targets
+func (r *Rule) targetDeviceID() uuid.UUID {
+
+	if r.DeviceID == nil || !r.targetsDevice() {
+		return uuid.Nil
+	}
+	return *r.DeviceID
+}
+
+func (r *Rule) targetValidations(db *gorm.DB, tenant_id uuid.UUID) []string {
+
+	var errs []string
+
+	switch r.Target {
+	case "", RuleTargetDevice:
+		var device Device
+		if r.DeviceID == nil {
+			errs = append(errs, "device_id is required")
+		} else if !device.IsValidDevice(db, *r.DeviceID, tenant_id) {
+			errs = append(errs, "invalid device_id")
+		}
+	case RuleTargetTenant:
+		if r.DeviceID != nil {
+			errs = append(errs, "device_id must be empty when the target is the tenant")
+		}
+	case RuleTargetTag, RuleTargetProfile:
+		if r.DeviceID != nil {
+			errs = append(errs, "device_id must be empty when the target is a "+r.Target)
+		}
+		if r.TargetValue == "" {
+			errs = append(errs, "target_value is required")
+		}
+		if len(r.TargetValue) > 255 {
+			errs = append(errs, "target_value is too long")
+		}
+	default:
+		errs = append(errs, "invalid target. The available targets are: device, tenant, tag and profile")
+	}
+
+	return errs
+}
+
+// ruleSensors returns the sensors the rule reads
+func (r *Rule) ruleSensors() []string {
+
+	if tree, err := r.conditionTree(); err == nil && tree != nil {
+		return tree.sensors()
+	}
+	return []string{r.Sensor}
+}
+
+// TargetDevices returns the active devices the rule watches. Devices of a group
+// are only included when they have a sensor of the rule.
+func (r *Rule) TargetDevices(db *gorm.DB) []uuid.UUID {
+
+	var device_ids []uuid.UUID
+
+	if r.targetsDevice() {
+		if r.DeviceID != nil {
+			device_ids = append(device_ids, *r.DeviceID)
+		}
+		return device_ids
+	}
+
+	query := db.Model(&Device{}).
+		Where("tenant_id = ? AND status = ?", r.TenantID, "active").
+		Where("id IN (?)", db.Table("sensors").Select("device_id").Where("name IN (?)", r.ruleSensors()).QueryExpr())
+
+	switch r.Target {
+	case RuleTargetTag:
+		query = query.Where("id IN (?)", db.Table("device_tags").Select("device_id").Where("tag = ?", r.TargetValue).QueryExpr())
+	case RuleTargetProfile:
+		query = query.Where("profile = ?", r.TargetValue)
+	}
+
+	query.Order("created_at asc").Pluck("id", &device_ids)
+	return device_ids
+}
+
+// SampleDevice returns the device used to test or preview the rule. Group rules
+// use the requested device when it is one of their targets, or the first one.
+func (r *Rule) SampleDevice(db *gorm.DB, requested string) (uuid.UUID, error) {
+
+	if r.targetsDevice() {
+		return r.targetDeviceID(), nil
+	}
+
+	device_ids := r.TargetDevices(db)
+	if len(device_ids) == 0 {
+		return uuid.Nil, errors.New("the rule does not target any device")
+	}
+
+	for _, device_id := range device_ids {
+		if device_id.String() == requested {
+			return device_id, nil
+		}
+	}
+	return device_ids[0], nil
+}
+
+// findDeviceRules returns the rules of the device and the group rules of its
+// tenant, tags and profile
+func findDeviceRules(db *gorm.DB, device_id uuid.UUID) []Rule {
+
+	var rules []Rule
+
+	device := Device{}
+	if db.Select("id, tenant_id, profile").Where("id = ?", device_id).Take(&device).Error != nil {
+		return rules
+	}
+
+	tags := db.Table("device_tags").Select("tag").Where("device_id = ?", device_id).QueryExpr()
+
+	db.Where("device_id = ? OR (tenant_id = ? AND (target = ? OR (target = ? AND target_value = ?) OR (target = ? AND target_value IN (?))))",
+		device_id, device.TenantID, RuleTargetTenant, RuleTargetProfile, device.Profile, RuleTargetTag, tags).
+		Find(&rules)
+
+	return rules
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatDuration(t *testing.T) {
+
+	tests := []struct {
+		duration time.Duration
+		expected string
+	}{
+		{10 * time.Second, "10s"},
+		{90 * time.Second, "1m30s"},
+		{10 * time.Minute, "10m"},
+		{30 * time.Minute, "30m"},
+		{time.Hour, "1h"},
+		{90 * time.Minute, "1h30m"},
+		{10 * time.Hour, "10h"},
+		{time.Hour + 10*time.Second, "1h0m10s"},
+		{500 * time.Millisecond, "500ms"},
+		{0, "0s"},
+	}
+
+	for _, tt := range tests {
+		if value := formatDuration(tt.duration); value != tt.expected {
+			t.Fatalf("%v: expected %v, got %v", tt.duration.String(), tt.expected, value)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RuleSchedule limits the evaluation of a rule to some hours of the week, e.g.
+// {"timezone": "Europe/Madrid", "days": ["mon", "tue"], "ranges": [{"start": "08:00", "end": "18:00"}]}
+// A range whose end is before its start crosses midnight.
+type RuleSchedule struct {
+	Timezone string          `json:"timezone"`
+	Days     []string        `json:"days"`
+	Ranges   []ScheduleRange `json:"ranges"`
+}
+
+type ScheduleRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+var scheduleDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func (r *Rule) schedule() (*RuleSchedule, error) {
+
+	if len(r.Schedule) == 0 {
+		return nil, nil
+	}
+
+	schedule, _ := json.Marshal(r.Schedule)
+
+	var s RuleSchedule
+	if err := json.Unmarshal(schedule, &s); err != nil {
+		return nil, errors.New("invalid schedule")
+	}
+	return &s, nil
+}
+
+// minuteOfDay parses a "15:04" time
+func minuteOfDay(value string) (int, error) {
+
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (s *RuleSchedule) validate() []string {
+
+	var errs []string
+
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		errs = append(errs, "invalid schedule.timezone")
+	}
+	for _, day := range s.Days {
+		if _, ok := scheduleDays[strings.ToLower(day)]; !ok {
+			errs = append(errs, fmt.Sprintf("invalid schedule day %v. The available days are: mon, tue, wed, thu, fri, sat and sun", day))
+		}
+	}
+	for i, rg := range s.Ranges {
+		start, errStart := minuteOfDay(rg.Start)
+		end, errEnd := minuteOfDay(rg.End)
+		if errStart != nil || errEnd != nil {
+			errs = append(errs, fmt.Sprintf("invalid schedule.ranges.%v, the times must be HH:MM", i))
+		} else if start == end {
+			errs = append(errs, fmt.Sprintf("invalid schedule.ranges.%v, start and end are equal", i))
+		}
+	}
+	if len(s.Days) == 0 && len(s.Ranges) == 0 {
+		errs = append(errs, "schedule requires days or ranges")
+	}
+
+	return errs
+}
+
+// activeAt checks if the schedule includes the time. Without days every day is
+// included and without ranges the whole day is.
+func (s *RuleSchedule) activeAt(t time.Time) bool {
+
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return true
+	}
+	t = t.In(location)
+	minute := t.Hour()*60 + t.Minute()
+
+	dayIncluded := func(day time.Weekday) bool {
+		if len(s.Days) == 0 {
+			return true
+		}
+		for _, d := range s.Days {
+			if weekday, ok := scheduleDays[strings.ToLower(d)]; ok && weekday == day {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(s.Ranges) == 0 {
+		return dayIncluded(t.Weekday())
+	}
+
+	for _, rg := range s.Ranges {
+		start, errStart := minuteOfDay(rg.Start)
+		end, errEnd := minuteOfDay(rg.End)
+		if errStart != nil || errEnd != nil {
+			continue
+		}
+
+		if start < end {
+			if minute >= start && minute < end && dayIncluded(t.Weekday()) {
+				return true
+			}
+			continue
+		}
+
+		// the range crosses midnight, the part after midnight belongs to the previous day
+		if minute >= start && dayIncluded(t.Weekday()) {
+			return true
+		}
+		if minute < end && dayIncluded((t.Weekday()+6)%7) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isScheduledAt checks the schedule of the rule, rules without one are always active
+func (r *Rule) isScheduledAt(t time.Time) bool {
+
+	s, err := r.schedule()
+	if err != nil || s == nil {
+		return true
+	}
+	return s.activeAt(t)
+}
+
+// nextScheduledAt returns the first minute from t until the end when the rule
+// is scheduled. The schedule repeats every week, one week is enough to search.
+func (r *Rule) nextScheduledAt(t time.Time, until time.Time) (time.Time, bool) {
+
+	s, err := r.schedule()
+	if err != nil || s == nil || s.activeAt(t) {
+		return t, t.Before(until)
+	}
+
+	next := t.Truncate(time.Minute)
+	for i := 0; i < 7*24*60; i++ {
+		next = next.Add(time.Minute)
+		if !next.Before(until) {
+			return time.Time{}, false
+		}
+		if s.activeAt(next) {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
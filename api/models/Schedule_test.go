@@ -0,0 +1,87 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleActiveAt(t *testing.T) {
+
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Skip("the timezone database is not available")
+	}
+
+	workingHours := RuleSchedule{Timezone: "Europe/Madrid", Days: []string{"mon", "tue", "wed", "thu", "fri"}, Ranges: []ScheduleRange{{Start: "08:00", End: "18:00"}}}
+	fridayNight := RuleSchedule{Timezone: "UTC", Days: []string{"fri"}, Ranges: []ScheduleRange{{Start: "22:00", End: "06:00"}}}
+	sundays := RuleSchedule{Timezone: "UTC", Days: []string{"Sun"}}
+	everyMorning := RuleSchedule{Timezone: "UTC", Ranges: []ScheduleRange{{Start: "08:00", End: "09:00"}}}
+	invalidTimezone := RuleSchedule{Timezone: "Mars/Olympus", Days: []string{"mon"}}
+
+	// 2020-01-06 is a monday
+	tests := []struct {
+		name     string
+		schedule RuleSchedule
+		at       time.Time
+		expected bool
+	}{
+		{"inside the range", workingHours, time.Date(2020, 1, 6, 9, 0, 0, 0, madrid), true},
+		{"start included", workingHours, time.Date(2020, 1, 6, 8, 0, 0, 0, madrid), true},
+		{"end excluded", workingHours, time.Date(2020, 1, 6, 18, 0, 0, 0, madrid), false},
+		{"weekend", workingHours, time.Date(2020, 1, 11, 10, 0, 0, 0, madrid), false},
+		{"timezone inside", workingHours, time.Date(2020, 1, 6, 7, 30, 0, 0, time.UTC), true},
+		{"timezone outside", workingHours, time.Date(2020, 1, 6, 17, 30, 0, 0, time.UTC), false},
+		{"timezone previous day", workingHours, time.Date(2020, 1, 10, 23, 30, 0, 0, time.UTC), false},
+
+		{"before midnight", fridayNight, time.Date(2020, 1, 10, 23, 0, 0, 0, time.UTC), true},
+		{"after midnight of the previous weekday", fridayNight, time.Date(2020, 1, 11, 3, 0, 0, 0, time.UTC), true},
+		{"after midnight of another weekday", fridayNight, time.Date(2020, 1, 10, 3, 0, 0, 0, time.UTC), false},
+		{"after the end", fridayNight, time.Date(2020, 1, 11, 6, 0, 0, 0, time.UTC), false},
+		{"before the start", fridayNight, time.Date(2020, 1, 10, 21, 59, 0, 0, time.UTC), false},
+
+		{"whole day", sundays, time.Date(2020, 1, 5, 0, 0, 0, 0, time.UTC), true},
+		{"another day", sundays, time.Date(2020, 1, 6, 12, 0, 0, 0, time.UTC), false},
+		{"every day", everyMorning, time.Date(2020, 1, 11, 8, 30, 0, 0, time.UTC), true},
+		{"invalid timezone", invalidTimezone, time.Date(2020, 1, 7, 12, 0, 0, 0, time.UTC), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if active := tt.schedule.activeAt(tt.at); active != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, active)
+			}
+		})
+	}
+}
+
+func TestBacktestNoDataFollowsTheSchedule(t *testing.T) {
+
+	r := Rule{
+		Type:     RuleTypeNoData,
+		Sensor:   "temp",
+		Window:   "10m",
+		Schedule: JSONB{"timezone": "UTC", "ranges": []interface{}{map[string]interface{}{"start": "08:00", "end": "18:00"}}},
+	}
+
+	// values at 07:00 and 12:00, the silence after 07:10 is noticed at 08:00
+	data := samples(5*time.Hour, 1, 2)
+	from := time.Date(2020, 1, 1, 6, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 1, 1, 20, 0, 0, 0, time.UTC)
+
+	result := BacktestResult{Events: []BacktestEvent{}}
+	r.backtestNoData(data, from, to, &backtestAlert{rule: &r, result: &result})
+
+	expected := []BacktestEvent{
+		{CollectedAt: "2020-01-01T08:00:00.000Z", State: AlertStateFiring},
+		{CollectedAt: "2020-01-01T12:00:00.000Z", State: AlertStateResolved},
+		{CollectedAt: "2020-01-01T12:10:00.000Z", State: AlertStateFiring},
+	}
+	if len(result.Events) != len(expected) {
+		t.Fatalf("expected %v events, got %+v", len(expected), result.Events)
+	}
+	for i := range expected {
+		if result.Events[i].CollectedAt != expected[i].CollectedAt || result.Events[i].State != expected[i].State {
+			t.Fatalf("expected %+v, got %+v", expected, result.Events)
+		}
+	}
+}
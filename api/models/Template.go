@@ -0,0 +1,282 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TemplateContext is the data available to the notification templates of a rule,
+// e.g. {{.Device.Name}}: {{.Sensor.Name}} is {{.Value}}{{.Sensor.Unit}}
+type TemplateContext struct {
+	State       string
+	Value       string
+	LastValue   interface{}
+	CollectedAt string
+	Values      map[string]interface{}
+	Rule        TemplateRule
+	Device      TemplateDevice
+	Sensor      TemplateSensor
+	Tenant      TemplateTenant
+}
+
+type TemplateRule struct {
+	ID          uuid.UUID
+	Description string
+	Operator    string
+	Operation   string
+	Threshold   string
+}
+
+type TemplateDevice struct {
+	ID   uuid.UUID
+	Name string
+}
+
+type TemplateSensor struct {
+	Name string
+	Unit string
+}
+
+type TemplateTenant struct {
+	ID   uuid.UUID
+	Name string
+}
+
+// placeholders of the templates written before go templates were supported
+var legacyPlaceholders = strings.NewReplacer(
+	"$collected_at", "{{.CollectedAt}}",
+	"$device_id", "{{.Device.ID}}",
+	"$sensor", "{{.Sensor.Name}}",
+	"$value", "{{.LastValue}}",
+	"$state", "{{.State}}",
+)
+
+// newTemplateContext loads the device, sensor and tenant of the notification
+func (r *Rule) newTemplateContext(db *gorm.DB, device_id uuid.UUID, value string, lastData map[string]interface{}, state string) *TemplateContext {
+
+	ctx := TemplateContext{
+		State:       state,
+		Value:       value,
+		LastValue:   lastData[r.Sensor],
+		CollectedAt: fmt.Sprintf("%v", lastData["collected_at"]),
+		Values:      map[string]interface{}{},
+		Rule: TemplateRule{
+			ID:          r.ID,
+			Description: r.Description,
+			Operator:    r.Operator,
+			Operation:   r.Operation,
+			Threshold:   r.Value,
+		},
+		Device: TemplateDevice{ID: device_id},
+		Sensor: TemplateSensor{Name: r.Sensor},
+		Tenant: TemplateTenant{ID: r.TenantID},
+	}
+
+	for key, v := range lastData {
+		if key != "collected_at" {
+			ctx.Values[key] = v
+		}
+	}
+
+	device := Device{}
+	if db.Select("name").Where("id = ?", device_id).Take(&device).Error == nil {
+		ctx.Device.Name = device.Name
+	}
+
+	sensor := Sensor{}
+	if db.Select("unit").Where("device_id = ? AND name = ?", device_id, r.Sensor).Take(&sensor).Error == nil {
+		ctx.Sensor.Unit = sensor.Unit
+	}
+
+	tenant := Tenant{}
+	if db.Select("name").Where("id = ?", r.TenantID).Take(&tenant).Error == nil {
+		ctx.Tenant.Name = tenant.Name
+	}
+
+	return &ctx
+}
+
+// sampleTemplateContext is used to validate the templates and in previews without data
+func (r *Rule) sampleTemplateContext() *TemplateContext {
+
+	return &TemplateContext{
+		State:       AlertStateFiring,
+		Value:       r.Value,
+		LastValue:   r.Value,
+		CollectedAt: "2006-01-02T15:04:05.000Z",
+		Values:      map[string]interface{}{r.Sensor: r.Value},
+		Rule:        TemplateRule{ID: r.ID, Description: r.Description, Operator: r.Operator, Operation: r.Operation, Threshold: r.Value},
+		Device:      TemplateDevice{ID: r.targetDeviceID(), Name: "device"},
+		Sensor:      TemplateSensor{Name: r.Sensor},
+		Tenant:      TemplateTenant{ID: r.TenantID, Name: "tenant"},
+	}
+}
+
+func renderText(name string, text string, ctx *TemplateContext) (string, error) {
+
+	tmpl, err := texttemplate.New(name).Option("missingkey=zero").Parse(legacyPlaceholders.Replace(text))
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, ctx); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// renderHTML escapes the values, it is used for the email body
+func renderHTML(name string, text string, ctx *TemplateContext) (string, error) {
+
+	tmpl, err := htmltemplate.New(name).Option("missingkey=zero").Parse(legacyPlaceholders.Replace(text))
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, ctx); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// renderPayload renders every string of the payload as a template, so the values
+// are quoted by the json encoder instead of being replaced inside the json
+func renderPayload(payload interface{}, ctx *TemplateContext) (interface{}, error) {
+
+	switch p := payload.(type) {
+	case string:
+		return renderText("endpoint_payload", p, ctx)
+	case map[string]interface{}:
+		rendered := map[string]interface{}{}
+		for key, value := range p {
+			v, err := renderPayload(value, ctx)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = v
+		}
+		return rendered, nil
+	case JSONB:
+		return renderPayload(map[string]interface{}(p), ctx)
+	case []interface{}:
+		rendered := []interface{}{}
+		for _, value := range p {
+			v, err := renderPayload(value, ctx)
+			if err != nil {
+				return nil, err
+			}
+			rendered = append(rendered, v)
+		}
+		return rendered, nil
+	}
+
+	return payload, nil
+}
+
+// RenderedTemplates are the notification messages of a rule
+type RenderedTemplates struct {
+	EmailSubject    string      `json:"email_subject"`
+	EmailBody       string      `json:"email_body"`
+	EndpointPayload interface{} `json:"endpoint_payload"`
+}
+
+// RenderTemplates renders the email and webhook templates of the rule
+func (r *Rule) RenderTemplates(ctx *TemplateContext) (*RenderedTemplates, error) {
+
+	var rendered RenderedTemplates
+	var err error
+
+	rendered.EmailSubject, err = renderText("email_subject", r.EmailSubject, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.State == AlertStateResolved {
+		rendered.EmailSubject = "[RESOLVED] " + rendered.EmailSubject
+	}
+
+	rendered.EmailBody, err = renderHTML("email_body", r.EmailBody, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.EndpointPayload != nil {
+		rendered.EndpointPayload, err = renderPayload(r.EndpointPayload, ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &rendered, nil
+}
+
+// PreviewTemplates renders the templates with the latest data of the device, or
+// with sample values when the device has not sent data yet
+func (r *Rule) PreviewTemplates(dbm *mongo.Client, db *gorm.DB, device_id uuid.UUID) (*RenderedTemplates, error) {
+
+	// latest document of the device
+	var opt options.FindOptions
+	opt.SetLimit(1)
+	opt.SetSort(bson.M{"$natural": -1})
+
+	ctxFind, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := dbm.Database("siot").Collection(fmt.Sprintf("%v", device_id))
+	cur, err := collection.Find(ctxFind, bson.M{}, &opt)
+	if err != nil {
+		return nil, err
+	}
+
+	var data Data
+	if err = cur.All(ctxFind, &data.Data); err != nil {
+		return nil, errors.New("error returning data")
+	}
+
+	var lastData map[string]interface{}
+	if len(data.Data) > 0 {
+		lastData = data.Data[0]
+		delete(lastData, "_id")
+	}
+
+	ctx := r.sampleTemplateContext()
+	if len(lastData) > 0 {
+		ctx = r.newTemplateContext(db, device_id, fmt.Sprintf("%v", lastData[r.Sensor]), lastData, AlertStateFiring)
+	}
+
+	return r.RenderTemplates(ctx)
+}
+
+// templateValidations parses and executes the templates with a sample context,
+// unknown fields and syntax errors are reported
+func (r *Rule) templateValidations() []string {
+
+	var errs []string
+	ctx := r.sampleTemplateContext()
+
+	if _, err := renderText("email_subject", r.EmailSubject, ctx); err != nil {
+		errs = append(errs, "invalid email_subject template: "+err.Error())
+	}
+	if _, err := renderHTML("email_body", r.EmailBody, ctx); err != nil {
+		errs = append(errs, "invalid email_body template: "+err.Error())
+	}
+	if r.EndpointPayload != nil {
+		if _, err := renderPayload(r.EndpointPayload, ctx); err != nil {
+			errs = append(errs, "invalid endpoint_payload template: "+err.Error())
+		}
+	}
+
+	return errs
+}
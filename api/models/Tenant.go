@@ -1,6 +1,7 @@
 package models
 
 import (
+	"errors"
 	"html"
 	"net/http"
 	"siot/api/utils/formaterror"
@@ -19,6 +20,7 @@ type Tenant struct {
 	CreatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 	Status      string    `gorm:"size:255;default:'active'" json:"status"`
+	RequireMFA  *bool     `gorm:"default:false" json:"require_mfa"`
 	Users       []User    `gorm:"many2many:user_tenants;association_jointable_foreignkey:user_id" json:"-"`
 }
 
@@ -29,6 +31,14 @@ type UserTenant struct {
 	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 	Status    string    `gorm:"size:255;default:'active'" json:"status"`
+	Role      string    `gorm:"size:255;" json:"role"`
+}
+
+func (t *UserTenant) BeforeCreate() {
+
+	if !IsValidRole(t.Role) {
+		t.Role = RoleEditor
+	}
 }
 
 func (t *Tenant) BeforeCreate() {
@@ -36,6 +46,10 @@ func (t *Tenant) BeforeCreate() {
 	if t.Status != "active" && t.Status != "inactive" {
 		t.Status = "active"
 	}
+	if t.RequireMFA == nil {
+		requireMFA := false
+		t.RequireMFA = &requireMFA
+	}
 
 	t.Name = html.EscapeString(strings.TrimSpace(t.Name))
 	t.Description = html.EscapeString(strings.TrimSpace(t.Description))
@@ -130,22 +144,82 @@ func (t *UserTenant) ValidateTenantPermission(db *gorm.DB, user_id uuid.UUID, te
 	return -1
 }
 
-func (t *Tenant) SaveTenant(db *gorm.DB, user_id uuid.UUID) (*Tenant, error) {
-	var err error
+// GetRole returns the role of the user inside the tenant
+func (t *UserTenant) GetRole(db *gorm.DB, user_id uuid.UUID, tenant_id uuid.UUID) (string, error) {
+
+	var userTenant UserTenant
+	var err error = db.Where("user_id = ? AND tenant_id = ?", user_id, tenant_id).Take(&userTenant).Error
+	if err != nil {
+		return "", err
+	}
+
+	// memberships created before roles existed
+	if !IsValidRole(userTenant.Role) {
+		return RoleEditor, nil
+	}
+
+	return userTenant.Role, nil
+}
+
+func (t *UserTenant) UpdateRole(db *gorm.DB, user_id uuid.UUID, tenant_id uuid.UUID, current_role string) (*UserTenant, error) {
+
+	if !IsValidRole(t.Role) {
+		return nil, errors.New("invalid role. The available roles are: owner, admin, editor, viewer and device-operator")
+	}
 
-	// get user for the association
-	user := User{
-		ID: user_id,
+	role, err := t.GetRole(db, user_id, tenant_id)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	// only owners can grant or revoke the owner role
+	if (role == RoleOwner || t.Role == RoleOwner) && current_role != RoleOwner {
+		return nil, errors.New("only owners can change the owner role")
 	}
 
+	// a tenant can not be left without owners
+	if role == RoleOwner && t.Role != RoleOwner {
+		var owners int
+		db.Model(&UserTenant{}).Where("tenant_id = ? AND role = ?", tenant_id, RoleOwner).Count(&owners)
+		if owners < 2 {
+			return nil, errors.New("the tenant must have at least one owner")
+		}
+	}
+
+	var errUpdate error = db.Model(&UserTenant{}).Where("user_id = ? AND tenant_id = ?", user_id, tenant_id).Updates(map[string]interface{}{
+		"role":       t.Role,
+		"updated_at": time.Now(),
+	}).Error
+	if errUpdate != nil {
+		return nil, errUpdate
+	}
+
+	var userTenant UserTenant
+	var errGet error = db.Where("user_id = ? AND tenant_id = ?", user_id, tenant_id).Take(&userTenant).Error
+	if errGet != nil {
+		return nil, errGet
+	}
+
+	return &userTenant, nil
+}
+
+func (t *Tenant) SaveTenant(db *gorm.DB, user_id uuid.UUID) (*Tenant, error) {
+	var err error
+
 	// create tenant
 	err = db.Model(&Tenant{}).Create(&t).Error
 	if err != nil {
 		return &Tenant{}, err
 	}
 
-	// create association
-	var errAssociation error = db.Model(&t).Association("Users").Append([]User{user}).Error
+	// the creator of the tenant is its owner
+	userTenant := UserTenant{
+		UserID:   user_id,
+		TenantID: t.ID,
+		Role:     RoleOwner,
+	}
+
+	var errAssociation error = db.Create(&userTenant).Error
 	if errAssociation != nil {
 		return &Tenant{}, errAssociation
 	}
@@ -0,0 +1,224 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"siot/api/auth"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// RefreshToken is stored server side, only its hash is kept. Every refresh
+// replaces the token with a new one of the same family, and using a replaced
+// token again revokes the whole family.
+type RefreshToken struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	TokenHash  string     `gorm:"size:255;not null;unique" json:"-"`
+	FamilyID   uuid.UUID  `sql:"type:uuid" json:"-"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy *uuid.UUID `sql:"type:uuid" json:"-"`
+	UserID     uuid.UUID  `sql:"type:uuid REFERENCES users(id) ON DELETE CASCADE" json:"-"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// RevokedToken is an access token revoked before it expires, e.g. on logout
+type RevokedToken struct {
+	JTI       string    `gorm:"primary_key;size:255" json:"jti"`
+	UserID    uuid.UUID `sql:"type:uuid REFERENCES users(id) ON DELETE CASCADE" json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// TokenPair is returned on login and refresh
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// refreshTokenTTL is REFRESH_TOKEN_TTL, default 30d
+func refreshTokenTTL() time.Duration {
+	return envDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+func (t *RefreshToken) BeforeCreate() {
+
+	t.CreatedAt = time.Now()
+}
+
+func (t *RevokedToken) BeforeCreate() {
+
+	t.CreatedAt = time.Now()
+}
+
+// IssueTokens creates an access token and a refresh token of a new family
+func IssueTokens(db *gorm.DB, user *User) (*TokenPair, error) {
+	return issueTokens(db, user, uuid.New())
+}
+
+func issueTokens(db *gorm.DB, user *User, family_id uuid.UUID) (*TokenPair, error) {
+
+	accessToken, _, err := auth.CreateToken(user.ID, user.IsAdmin, user.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := "siotr_" + randStr(32)
+	token := RefreshToken{
+		TokenHash: hashApiKey(refresh),
+		FamilyID:  family_id,
+		ExpiresAt: time.Now().Add(refreshTokenTTL()),
+		UserID:    user.ID,
+	}
+
+	var errCreate error = db.Create(&token).Error
+	if errCreate != nil {
+		return nil, errCreate
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(auth.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// RefreshTokens rotates the refresh token and returns a new access token
+func RefreshTokens(db *gorm.DB, refresh string) (*TokenPair, error) {
+
+	token := RefreshToken{}
+	if db.Where("token_hash = ?", hashApiKey(refresh)).Take(&token).Error != nil {
+		return nil, errors.New("invalid refresh token")
+	}
+
+	// a replaced token was stolen or replayed, nobody keeps using the family
+	if token.RevokedAt != nil {
+		if token.ReplacedBy != nil {
+			revokeFamily(db, token.FamilyID)
+		}
+		return nil, errors.New("refresh token is revoked")
+	}
+	if token.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("refresh token is expired")
+	}
+
+	user := User{}
+	if db.Where("id = ?", token.UserID).Take(&user).Error != nil {
+		return nil, errors.New("user not found")
+	}
+	if user.Status != "active" {
+		return nil, errors.New("user is not active")
+	}
+	if user.TokensRevokedAt != nil && token.CreatedAt.Before(*user.TokensRevokedAt) {
+		return nil, errors.New("refresh token is revoked")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	// claim the token first, only one of the concurrent refreshes of the same
+	// token revokes it and gets a new pair
+	now := time.Now()
+	claim := tx.Model(&RefreshToken{}).Where("id = ? AND revoked_at IS NULL", token.ID).UpdateColumn("revoked_at", now)
+	if claim.Error != nil {
+		tx.Rollback()
+		return nil, claim.Error
+	}
+	if claim.RowsAffected != 1 {
+		tx.Rollback()
+		return nil, errors.New("refresh token is revoked")
+	}
+
+	pair, err := issueTokens(tx, &user, token.FamilyID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	replacement := RefreshToken{}
+	if err := tx.Where("token_hash = ?", hashApiKey(pair.RefreshToken)).Take(&replacement).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var errReplace error = tx.Model(&RefreshToken{}).Where("id = ?", token.ID).UpdateColumn("replaced_by", replacement.ID).Error
+	if errReplace != nil {
+		tx.Rollback()
+		return nil, errReplace
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+func revokeFamily(db *gorm.DB, family_id uuid.UUID) error {
+	return db.Model(&RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", family_id).UpdateColumn("revoked_at", time.Now()).Error
+}
+
+// Logout revokes the access token and, when sent, the refresh token of the session
+func Logout(db *gorm.DB, user_id uuid.UUID, jti string, expires_at time.Time, refresh string) error {
+
+	pruneTokens(db)
+
+	if jti != "" {
+		var err error = db.Create(&RevokedToken{JTI: jti, UserID: user_id, ExpiresAt: expires_at}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if refresh != "" {
+		token := RefreshToken{}
+		if db.Where("token_hash = ? AND user_id = ?", hashApiKey(refresh), user_id).Take(&token).Error == nil {
+			return revokeFamily(db, token.FamilyID)
+		}
+	}
+
+	return nil
+}
+
+// RevokeUserTokens revokes every access and refresh token issued to the user
+func RevokeUserTokens(db *gorm.DB, user_id uuid.UUID) error {
+
+	now := time.Now()
+
+	var err error = db.Model(&User{}).Where("id = ?", user_id).UpdateColumn("tokens_revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Model(&RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", user_id).UpdateColumn("revoked_at", now).Error
+}
+
+// IsTokenRevoked checks the revocation list and the last revocation of the user
+func IsTokenRevoked(db *gorm.DB, jti string, user_id string, issued_at time.Time) bool {
+
+	var count int
+	db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count)
+	if count > 0 {
+		return true
+	}
+
+	user := User{}
+	if db.Select("tokens_revoked_at").Where("id = ?", user_id).Take(&user).Error != nil {
+		return true
+	}
+
+	return user.TokensRevokedAt != nil && issued_at.Before(*user.TokensRevokedAt)
+}
+
+// pruneTokens deletes the revoked and refresh tokens that already expired
+func pruneTokens(db *gorm.DB) {
+
+	now := time.Now()
+	db.Where("expires_at < ?", now).Delete(&RevokedToken{})
+	db.Where("expires_at < ?", now).Delete(&RefreshToken{})
+}
@@ -20,18 +20,22 @@ import (
 )
 
 type User struct {
-	ID              uuid.UUID `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
-	FirstName       string    `validate:"required" gorm:"size:255;not null" json:"first_name"`
-	LastName        string    `validate:"required" gorm:"size:255;not null" json:"last_name"`
-	Email           string    `validate:"email,required" gorm:"size:100;not null;unique" json:"email"`
-	Password        string    `validate:"required" gorm:"size:100;not null;" json:"password,omitempty"`
-	IsAdmin         bool      `gorm:"default:false" json:"-"`
-	IsSuperAdmin    bool      `gorm:"default:false" json:"-"`
-	Status          string    `gorm:"size:255;default:'active'"`
-	InvitationToken string    `gorm:"size:255;" json:"-"`
-	CreatedAt       time.Time `validate:"required" gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt       time.Time `validate:"required" gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
-	Tenants         []Tenant  `gorm:"many2many:user_tenants;association_jointable_foreignkey:tenant_id" json:"tenants"`
+	ID              uuid.UUID  `gorm:"type:uuid;default:public.uuid_generate_v4()" json:"id"`
+	FirstName       string     `validate:"required" gorm:"size:255;not null" json:"first_name"`
+	LastName        string     `validate:"required" gorm:"size:255;not null" json:"last_name"`
+	Email           string     `validate:"email,required" gorm:"size:100;not null;unique" json:"email"`
+	Password        string     `validate:"required" gorm:"size:100;not null;" json:"password,omitempty"`
+	IsAdmin         bool       `gorm:"default:false" json:"-"`
+	IsSuperAdmin    bool       `gorm:"default:false" json:"-"`
+	Status          string     `gorm:"size:255;default:'active'"`
+	InvitationToken string     `gorm:"size:255;" json:"-"`
+	TokensRevokedAt *time.Time `json:"-"`
+	MFAEnabled      bool       `gorm:"default:false" json:"mfa_enabled"`
+	MFASecret       string     `gorm:"size:255" json:"-"`
+	MFALastStep     int64      `gorm:"default:0" json:"-"`
+	CreatedAt       time.Time  `validate:"required" gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt       time.Time  `validate:"required" gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	Tenants         []Tenant   `gorm:"many2many:user_tenants;association_jointable_foreignkey:tenant_id" json:"tenants"`
 }
 
 func (u *User) ShowUserSerializer() serializers.ShowUserSerializer {
@@ -45,14 +49,15 @@ func (u *User) ShowUserSerializer() serializers.ShowUserSerializer {
 	}
 
 	return serializers.ShowUserSerializer{
-		ID:        u.ID,
-		FirstName: u.FirstName,
-		LastName:  u.LastName,
-		Email:     u.Email,
-		Status:    u.Status,
-		CreatedAt: u.CreatedAt,
-		UpdatedAt: u.UpdatedAt,
-		Tenants:   tenants,
+		ID:         u.ID,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		Email:      u.Email,
+		Status:     u.Status,
+		MFAEnabled: u.MFAEnabled,
+		CreatedAt:  u.CreatedAt,
+		UpdatedAt:  u.UpdatedAt,
+		Tenants:    tenants,
 	}
 }
 
@@ -125,14 +130,9 @@ func (u *User) UserValidations(action string, db *gorm.DB) formaterror.GeneralEr
 	var errors formaterror.GeneralError
 
 	switch strings.ToLower(action) {
-	case "confirm":
+	case "confirm", "password":
 
-		if u.Password == "" {
-			errors.Errors = append(errors.Errors, "password is required")
-		}
-		if len(u.Password) < 5 {
-			errors.Errors = append(errors.Errors, "password must have at least 5 characters")
-		}
+		errors.Errors = append(errors.Errors, passwordValidations(u.Password, u.Email)...)
 
 		return errors
 
@@ -224,7 +224,7 @@ func (u *User) SaveUser(db *gorm.DB) (*User, error) {
 	return u, nil
 }
 
-func (u *User) SaveUserTenant(db *gorm.DB, tenant uuid.UUID) (*User, error) {
+func (u *User) SaveUserTenant(db *gorm.DB, tenant uuid.UUID, role string) (*User, error) {
 
 	u.Status = "invited"
 	u.InvitationToken = randStr(30)
@@ -239,6 +239,7 @@ func (u *User) SaveUserTenant(db *gorm.DB, tenant uuid.UUID) (*User, error) {
 	var userTenant UserTenant
 	userTenant.TenantID = tenant
 	userTenant.UserID = u.ID
+	userTenant.Role = role
 
 	var errUserTenant error = db.Create(&userTenant).Error
 	if errUserTenant != nil {
@@ -279,6 +280,39 @@ func (u *User) ConfirmUser(db *gorm.DB, r *http.Request) (*User, error) {
 	return &user, nil
 }
 
+// UpdateStatus activates or deactivates the user. Every token issued to the user
+// is revoked when the status changes.
+func (u *User) UpdateStatus(db *gorm.DB, user_id string, status string) (*User, error) {
+
+	status = strings.ToLower(strings.TrimSpace(status))
+	if status != "active" && status != "inactive" {
+		return nil, errors.New("invalid status. The available status are: active and inactive")
+	}
+
+	var err error = db.Model(&User{}).Where("id = ?", user_id).Take(&u).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Status == status {
+		return u, nil
+	}
+
+	var err_update error = db.Model(&User{}).Where("id = ?", user_id).Updates(map[string]interface{}{
+		"status":     status,
+		"updated_at": time.Now(),
+	}).Error
+	if err_update != nil {
+		return nil, err_update
+	}
+
+	if err := RevokeUserTokens(db, u.ID); err != nil {
+		return nil, err
+	}
+
+	return u.FindUserByID(db, user_id)
+}
+
 func (u *User) FindAllUsers(db *gorm.DB) (*[]User, error) {
 
 	var err error
@@ -0,0 +1,278 @@
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrNotAuthorized makes the broker drop the connection of the publishing client
+var ErrNotAuthorized = errors.New("not authorized")
+
+// Session holds the credentials a client sent on CONNECT
+type Session struct {
+	ClientID string
+	Username string
+	Password string
+}
+
+// Handler authenticates clients and receives every message they publish
+type Handler interface {
+	Authenticate(session *Session) error
+	Publish(session *Session, topic string, payload []byte) error
+}
+
+// Broker is a minimal MQTT 3.1.1 listener that only accepts publishes from
+// clients. It is enough for devices to push data without an external broker.
+type Broker struct {
+	Handler Handler
+
+	// MaxPacketSize limits the packets after CONNECT, 256KB when zero
+	MaxPacketSize int
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+func NewBroker(handler Handler) *Broker {
+	return &Broker{
+		Handler: handler,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves incoming clients
+func (b *Broker) ListenAndServe(addr string) error {
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return b.Serve(l)
+}
+
+// Serve accepts clients on l until the broker is closed
+func (b *Broker) Serve(l net.Listener) error {
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		l.Close()
+		return errors.New("broker closed")
+	}
+	b.listeners = append(b.listeners, l)
+	b.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			b.mu.Lock()
+			closed := b.closed
+			b.mu.Unlock()
+
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		go b.ServeConn(conn)
+	}
+}
+
+// ServeConn handles a single client connection until it disconnects. It can be
+// used with net.Pipe to run the broker in-process without any network.
+func (b *Broker) ServeConn(conn net.Conn) {
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		conn.Close()
+		return
+	}
+	b.conns[conn] = struct{}{}
+	b.wg.Add(1)
+	b.mu.Unlock()
+
+	defer func() {
+		conn.Close()
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+		b.wg.Done()
+	}()
+
+	if err := b.serve(conn); err != nil {
+		log.Printf("mqtt: client %v: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// Close stops the listeners, disconnects every client and waits for them to finish
+func (b *Broker) Close() error {
+
+	b.mu.Lock()
+	b.closed = true
+	for _, l := range b.listeners {
+		l.Close()
+	}
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return nil
+}
+
+func (b *Broker) serve(conn net.Conn) error {
+
+	r := bufio.NewReader(conn)
+
+	// the first packet must be CONNECT
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	p, err := readPacket(r, maxConnectLength)
+	if err != nil {
+		return err
+	}
+
+	if p.Type != packetConnect {
+		return errors.New("expected CONNECT packet")
+	}
+
+	connect, err := parseConnect(p)
+	if err != nil {
+		return err
+	}
+
+	if connect.ProtocolLevel != 3 && connect.ProtocolLevel != 4 {
+		writePacket(conn, packetConnack, 0, []byte{0, connackBadProtocolVersion})
+		return errors.New("unsupported protocol level")
+	}
+
+	session := &Session{
+		ClientID: connect.ClientID,
+		Username: connect.Username,
+		Password: connect.Password,
+	}
+
+	if session.Password == "" {
+		writePacket(conn, packetConnack, 0, []byte{0, connackBadCredentials})
+		return errors.New("missing credentials")
+	}
+
+	if err := b.Handler.Authenticate(session); err != nil {
+		writePacket(conn, packetConnack, 0, []byte{0, connackNotAuthorized})
+		return err
+	}
+
+	if err := writePacket(conn, packetConnack, 0, []byte{0, connackAccepted}); err != nil {
+		return err
+	}
+
+	// keep alive grace period is one and a half times the client value
+	keepAlive := time.Duration(connect.KeepAlive) * time.Second * 3 / 2
+
+	maxPacketSize := b.MaxPacketSize
+	if maxPacketSize <= 0 {
+		maxPacketSize = defaultMaxPacketSize
+	}
+
+	for {
+		if keepAlive > 0 {
+			conn.SetReadDeadline(time.Now().Add(keepAlive))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+
+		p, err := readPacket(r, maxPacketSize)
+		if err != nil {
+			return err
+		}
+
+		switch p.Type {
+		case packetPublish:
+			pub, err := parsePublish(p)
+			if err != nil {
+				return err
+			}
+
+			errPublish := b.Handler.Publish(session, pub.Topic, pub.Payload)
+			if errPublish == ErrNotAuthorized {
+				return errPublish
+			}
+			if errPublish != nil {
+				log.Printf("mqtt: client %s: publish to %s: %v", session.ClientID, pub.Topic, errPublish)
+			}
+
+			if pub.QoS == 1 {
+				err = writePacket(conn, packetPuback, 0, packetIDBytes(pub.PacketID))
+			} else if pub.QoS == 2 {
+				err = writePacket(conn, packetPubrec, 0, packetIDBytes(pub.PacketID))
+			}
+			if err != nil {
+				return err
+			}
+
+		case packetPubrel:
+			id, err := parsePacketID(p)
+			if err != nil {
+				return err
+			}
+			if err := writePacket(conn, packetPubcomp, 0, packetIDBytes(id)); err != nil {
+				return err
+			}
+
+		case packetSubscribe:
+			// devices can only publish, every subscription is refused
+			id, err := parsePacketID(p)
+			if err != nil {
+				return err
+			}
+
+			body := packetIDBytes(id)
+			offset := 2
+			for offset < len(p.Body) {
+				if _, offset, err = readBytes(p.Body, offset); err != nil {
+					return err
+				}
+				offset++
+				body = append(body, 0x80)
+			}
+
+			if err := writePacket(conn, packetSuback, 0, body); err != nil {
+				return err
+			}
+
+		case packetUnsubscribe:
+			id, err := parsePacketID(p)
+			if err != nil {
+				return err
+			}
+			if err := writePacket(conn, packetUnsuback, 0, packetIDBytes(id)); err != nil {
+				return err
+			}
+
+		case packetPingreq:
+			if err := writePacket(conn, packetPingresp, 0, nil); err != nil {
+				return err
+			}
+
+		case packetDisconnect:
+			return nil
+
+		case packetPuback, packetPubrec, packetPubcomp:
+			// the broker never publishes, nothing to acknowledge
+
+		default:
+			return errMalformedPacket
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type published struct {
+	Topic   string
+	Payload string
+}
+
+// testHandler accepts the password "secret" and records the publishes
+type testHandler struct {
+	mu        sync.Mutex
+	published []published
+}
+
+func (h *testHandler) Authenticate(session *Session) error {
+	if session.Password != "secret" {
+		return errors.New("bad password")
+	}
+	return nil
+}
+
+func (h *testHandler) Publish(session *Session, topic string, payload []byte) error {
+	if topic == "forbidden" {
+		return ErrNotAuthorized
+	}
+	h.mu.Lock()
+	h.published = append(h.published, published{Topic: topic, Payload: string(payload)})
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *testHandler) messages() []published {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]published{}, h.published...)
+}
+
+func encodeString(s string) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func connectBody(username, password string) []byte {
+
+	body := encodeString("MQTT")
+	flags := byte(0x02)
+	if username != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+	body = append(body, 4, flags, 0, 60)
+	body = append(body, encodeString("client-1")...)
+	if username != "" {
+		body = append(body, encodeString(username)...)
+	}
+	if password != "" {
+		body = append(body, encodeString(password)...)
+	}
+	return body
+}
+
+// startBroker serves one client over net.Pipe and returns the client side
+func startBroker(t *testing.T, b *Broker) (net.Conn, *bufio.Reader, chan struct{}) {
+
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		b.ServeConn(server)
+		close(done)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	return client, bufio.NewReader(client), done
+}
+
+func connect(t *testing.T, client net.Conn, r *bufio.Reader, username, password string) byte {
+
+	if err := writePacket(client, packetConnect, 0, connectBody(username, password)); err != nil {
+		t.Fatalf("write CONNECT: %v", err)
+	}
+
+	p, err := readPacket(r, maxConnectLength)
+	if err != nil {
+		t.Fatalf("read CONNACK: %v", err)
+	}
+	if p.Type != packetConnack || len(p.Body) != 2 {
+		t.Fatalf("expected CONNACK, got type %v body %v", p.Type, p.Body)
+	}
+	return p.Body[1]
+}
+
+func waitClosed(t *testing.T, r *bufio.Reader, done chan struct{}) {
+
+	// the broker closes its side, the client reads EOF
+	if _, err := r.ReadByte(); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("broker did not release the connection")
+	}
+}
+
+func TestConnect(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		code     byte
+	}{
+		{"accepted", "device", "secret", connackAccepted},
+		{"wrong password", "device", "wrong", connackNotAuthorized},
+		{"missing password", "device", "", connackBadCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			b := NewBroker(&testHandler{})
+			client, r, done := startBroker(t, b)
+			defer client.Close()
+
+			if code := connect(t, client, r, tt.username, tt.password); code != tt.code {
+				t.Fatalf("expected return code %v, got %v", tt.code, code)
+			}
+
+			if tt.code != connackAccepted {
+				waitClosed(t, r, done)
+			}
+		})
+	}
+}
+
+func TestFirstPacketMustBeConnect(t *testing.T) {
+
+	b := NewBroker(&testHandler{})
+	client, r, done := startBroker(t, b)
+	defer client.Close()
+
+	go writePacket(client, packetPingreq, 0, nil)
+	waitClosed(t, r, done)
+}
+
+func TestPublish(t *testing.T) {
+
+	h := &testHandler{}
+	b := NewBroker(h)
+	client, r, done := startBroker(t, b)
+	defer client.Close()
+
+	if code := connect(t, client, r, "device", "secret"); code != connackAccepted {
+		t.Fatalf("connect refused with code %v", code)
+	}
+
+	// QoS 0, no acknowledgement
+	body := append(encodeString("siot/t/d/data"), `{"a":1}`...)
+	if err := writePacket(client, packetPublish, 0, body); err != nil {
+		t.Fatal(err)
+	}
+
+	// QoS 1 is acknowledged with PUBACK and the same packet id
+	body = append(encodeString("siot/t/d/data"), 0x12, 0x34)
+	body = append(body, `{"a":2}`...)
+	if err := writePacket(client, packetPublish, 0x02, body); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := readPacket(r, defaultMaxPacketSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != packetPuback || binary.BigEndian.Uint16(p.Body) != 0x1234 {
+		t.Fatalf("expected PUBACK 0x1234, got type %v body %v", p.Type, p.Body)
+	}
+
+	// ping after the publishes
+	if err := writePacket(client, packetPingreq, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = readPacket(r, defaultMaxPacketSize); err != nil || p.Type != packetPingresp {
+		t.Fatalf("expected PINGRESP, got %v %v", p, err)
+	}
+
+	messages := h.messages()
+	if len(messages) != 2 || messages[0].Payload != `{"a":1}` || messages[1].Payload != `{"a":2}` {
+		t.Fatalf("unexpected messages %+v", messages)
+	}
+
+	// a publish the handler does not authorize drops the client
+	if err := writePacket(client, packetPublish, 0, encodeString("forbidden")); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, r, done)
+}
+
+func TestMalformedLength(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		header []byte
+	}{
+		// five continuation bytes, the remaining length uses at most four
+		{"too many length bytes", []byte{packetConnect << 4, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+		// 256MB announced before the client is authenticated
+		{"huge connect", []byte{packetConnect << 4, 0xff, 0xff, 0xff, 0x7f}},
+		// above the connect limit, under the protocol limit
+		{"connect above limit", []byte{packetConnect << 4, 0x81, 0x40}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			b := NewBroker(&testHandler{})
+			client, r, done := startBroker(t, b)
+			defer client.Close()
+
+			go client.Write(tt.header)
+			waitClosed(t, r, done)
+		})
+	}
+}
+
+func TestPacketAboveMaxSize(t *testing.T) {
+
+	h := &testHandler{}
+	b := NewBroker(h)
+	b.MaxPacketSize = 64
+	client, r, done := startBroker(t, b)
+	defer client.Close()
+
+	if code := connect(t, client, r, "device", "secret"); code != connackAccepted {
+		t.Fatalf("connect refused with code %v", code)
+	}
+
+	body := append(encodeString("siot/t/d/data"), make([]byte, 100)...)
+	go writePacket(client, packetPublish, 0, body)
+
+	waitClosed(t, r, done)
+	if len(h.messages()) != 0 {
+		t.Fatal("the large packet was published")
+	}
+}
+
+func TestReadPacketLimit(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		data    []byte
+		max     int
+		wantErr error
+	}{
+		{"empty body", []byte{packetPingreq << 4, 0}, 0, nil},
+		{"at limit", []byte{packetPublish << 4, 3, 0, 1, 'a'}, 3, nil},
+		{"above limit", []byte{packetPublish << 4, 4, 0, 1, 'a', 'b'}, 3, errPacketTooLarge},
+		{"too many length bytes", []byte{packetPublish << 4, 0x80, 0x80, 0x80, 0x80, 0x01}, defaultMaxPacketSize, errMalformedPacket},
+		{"truncated body", []byte{packetPublish << 4, 5, 0, 1}, defaultMaxPacketSize, io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(tt.data))
+			_, err := readPacket(r, tt.max)
+			if err != tt.wantErr {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// control packet types (MQTT 3.1.1)
+const (
+	packetConnect     byte = 1
+	packetConnack     byte = 2
+	packetPublish     byte = 3
+	packetPuback      byte = 4
+	packetPubrec      byte = 5
+	packetPubrel      byte = 6
+	packetPubcomp     byte = 7
+	packetSubscribe   byte = 8
+	packetSuback      byte = 9
+	packetUnsubscribe byte = 10
+	packetUnsuback    byte = 11
+	packetPingreq     byte = 12
+	packetPingresp    byte = 13
+	packetDisconnect  byte = 14
+)
+
+// connack return codes
+const (
+	connackAccepted           byte = 0
+	connackBadProtocolVersion byte = 1
+	connackBadCredentials     byte = 4
+	connackNotAuthorized      byte = 5
+)
+
+const maxRemainingLength = 268435455
+
+// size limits of the remaining length. CONNECT is read before the client is
+// authenticated, so it is kept small.
+const (
+	maxConnectLength     = 4096
+	defaultMaxPacketSize = 256 * 1024
+)
+
+var errMalformedPacket = errors.New("malformed packet")
+
+var errPacketTooLarge = errors.New("packet too large")
+
+type packet struct {
+	Type  byte
+	Flags byte
+	Body  []byte
+}
+
+type connectPacket struct {
+	ProtocolName  string
+	ProtocolLevel byte
+	KeepAlive     uint16
+	ClientID      string
+	Username      string
+	Password      string
+}
+
+type publishPacket struct {
+	Topic    string
+	QoS      byte
+	PacketID uint16
+	Payload  []byte
+}
+
+// readPacket reads the next packet, the body is not allocated when its length is
+// above maxLength
+func readPacket(r *bufio.Reader, maxLength int) (*packet, error) {
+
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	// remaining length is encoded in up to four bytes
+	length := 0
+	multiplier := 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, errMalformedPacket
+		}
+
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		length += int(b&127) * multiplier
+		multiplier *= 128
+
+		if b&128 == 0 {
+			break
+		}
+	}
+
+	if length > maxRemainingLength {
+		return nil, errMalformedPacket
+	}
+	if length > maxLength {
+		return nil, errPacketTooLarge
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	return &packet{Type: header >> 4, Flags: header & 0x0f, Body: body}, nil
+}
+
+func writePacket(w io.Writer, packetType, flags byte, body []byte) error {
+
+	buf := []byte{packetType<<4 | flags}
+
+	// remaining length
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length = length / 128
+		if length > 0 {
+			b = b | 128
+		}
+		buf = append(buf, b)
+		if length == 0 {
+			break
+		}
+	}
+
+	buf = append(buf, body...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func readString(body []byte, offset int) (string, int, error) {
+
+	value, offset, err := readBytes(body, offset)
+	if err != nil {
+		return "", offset, err
+	}
+
+	return string(value), offset, nil
+}
+
+func readBytes(body []byte, offset int) ([]byte, int, error) {
+
+	if len(body) < offset+2 {
+		return nil, offset, errMalformedPacket
+	}
+
+	length := int(binary.BigEndian.Uint16(body[offset:]))
+	offset += 2
+
+	if len(body) < offset+length {
+		return nil, offset, errMalformedPacket
+	}
+
+	return body[offset : offset+length], offset + length, nil
+}
+
+func parseConnect(p *packet) (*connectPacket, error) {
+
+	var c connectPacket
+	var err error
+	offset := 0
+
+	c.ProtocolName, offset, err = readString(p.Body, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.Body) < offset+4 {
+		return nil, errMalformedPacket
+	}
+
+	c.ProtocolLevel = p.Body[offset]
+	flags := p.Body[offset+1]
+	c.KeepAlive = binary.BigEndian.Uint16(p.Body[offset+2:])
+	offset += 4
+
+	c.ClientID, offset, err = readString(p.Body, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// will topic and will message are read and discarded
+	if flags&0x04 != 0 {
+		if _, offset, err = readBytes(p.Body, offset); err != nil {
+			return nil, err
+		}
+		if _, offset, err = readBytes(p.Body, offset); err != nil {
+			return nil, err
+		}
+	}
+
+	if flags&0x80 != 0 {
+		c.Username, offset, err = readString(p.Body, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if flags&0x40 != 0 {
+		c.Password, _, err = readString(p.Body, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &c, nil
+}
+
+func parsePublish(p *packet) (*publishPacket, error) {
+
+	var pub publishPacket
+	var err error
+	offset := 0
+
+	pub.QoS = (p.Flags >> 1) & 0x03
+	if pub.QoS > 2 {
+		return nil, errMalformedPacket
+	}
+
+	pub.Topic, offset, err = readString(p.Body, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if pub.QoS > 0 {
+		if len(p.Body) < offset+2 {
+			return nil, errMalformedPacket
+		}
+		pub.PacketID = binary.BigEndian.Uint16(p.Body[offset:])
+		offset += 2
+	}
+
+	pub.Payload = p.Body[offset:]
+
+	return &pub, nil
+}
+
+func parsePacketID(p *packet) (uint16, error) {
+
+	if len(p.Body) < 2 {
+		return 0, errMalformedPacket
+	}
+
+	return binary.BigEndian.Uint16(p.Body), nil
+}
+
+func packetIDBytes(id uint16) []byte {
+
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, id)
+	return b
+}
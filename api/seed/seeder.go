@@ -18,7 +18,7 @@ func Load(db *gorm.DB) {
 	// }
 
 	// Migration
-	err := db.AutoMigrate(&models.User{}, &models.Tenant{}, &models.UserTenant{}, &models.Device{}, &models.Sensor{}, &models.Rule{}).Error
+	err := db.AutoMigrate(&models.User{}, &models.Tenant{}, &models.UserTenant{}, &models.Device{}, &models.Sensor{}, &models.Rule{}, &models.Command{}, &models.ApiKey{}, &models.Alert{}, &models.RuleEvent{}, &models.NotificationChannel{}, &models.Delivery{}, &models.RuleChannel{}, &models.EscalationPolicy{}, &models.EscalationStep{}, &models.MaintenanceWindow{}, &models.DeviceTag{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.PasswordReset{}, &models.RecoveryCode{}, &models.OIDCLogin{}, &models.LoginThrottle{}).Error
 	if err != nil {
 		log.Fatalf("cannot migrate table: %v", err)
 	}
@@ -28,15 +28,70 @@ func Load(db *gorm.DB) {
 	db.Table("user_tenants").AddForeignKey("user_id", "users(id)", "CASCADE", "CASCADE")
 	db.Table("user_tenants").AddForeignKey("tenant_id", "tenants(id)", "CASCADE", "CASCADE")
 
+	// tokens
+	db.Table("refresh_tokens").AddForeignKey("user_id", "users(id)", "CASCADE", "CASCADE")
+	db.Table("revoked_tokens").AddForeignKey("user_id", "users(id)", "CASCADE", "CASCADE")
+	db.Table("password_resets").AddForeignKey("user_id", "users(id)", "CASCADE", "CASCADE")
+	db.Table("recovery_codes").AddForeignKey("user_id", "users(id)", "CASCADE", "CASCADE")
+
 	// devices
 	db.Table("devices").AddForeignKey("tenant_id", "tenants(id)", "CASCADE", "CASCADE")
 
+	// device tags
+	db.Table("device_tags").AddForeignKey("device_id", "devices(id)", "CASCADE", "CASCADE")
+
 	// sensors
 	db.Table("sensors").AddForeignKey("device_id", "devices(id)", "CASCADE", "CASCADE")
 
 	// rules
 	db.Table("rules").AddForeignKey("device_id", "devices(id)", "CASCADE", "CASCADE")
 
+	// commands
+	db.Table("commands").AddForeignKey("device_id", "devices(id)", "CASCADE", "CASCADE")
+
+	// api keys
+	db.Table("api_keys").AddForeignKey("tenant_id", "tenants(id)", "CASCADE", "CASCADE")
+
+	// alerts
+	db.Table("alerts").AddForeignKey("rule_id", "rules(id)", "CASCADE", "CASCADE")
+	db.Table("alerts").AddForeignKey("device_id", "devices(id)", "CASCADE", "CASCADE")
+
+	// rule events
+	db.Table("rule_events").AddForeignKey("rule_id", "rules(id)", "CASCADE", "CASCADE")
+	db.Table("rule_events").AddForeignKey("device_id", "devices(id)", "CASCADE", "CASCADE")
+
+	// notification channels
+	db.Table("notification_channels").AddForeignKey("tenant_id", "tenants(id)", "CASCADE", "CASCADE")
+	db.Table("rule_channels").AddForeignKey("rule_id", "rules(id)", "CASCADE", "CASCADE")
+	db.Table("rule_channels").AddForeignKey("channel_id", "notification_channels(id)", "CASCADE", "CASCADE")
+
+	// escalation policies
+	db.Table("escalation_policies").AddForeignKey("tenant_id", "tenants(id)", "CASCADE", "CASCADE")
+	db.Table("escalation_steps").AddForeignKey("policy_id", "escalation_policies(id)", "CASCADE", "CASCADE")
+	db.Table("escalation_steps").AddForeignKey("channel_id", "notification_channels(id)", "CASCADE", "CASCADE")
+	db.Table("rules").AddForeignKey("escalation_policy_id", "escalation_policies(id)", "SET NULL", "CASCADE")
+	db.Table("alerts").AddForeignKey("acknowledged_by", "users(id)", "SET NULL", "CASCADE")
+
+	// maintenance windows
+	db.Table("maintenance_windows").AddForeignKey("tenant_id", "tenants(id)", "CASCADE", "CASCADE")
+	db.Table("maintenance_windows").AddForeignKey("device_id", "devices(id)", "CASCADE", "CASCADE")
+
+	// deliveries
+	db.Table("deliveries").AddForeignKey("rule_id", "rules(id)", "CASCADE", "CASCADE")
+
+	// signing secrets of rules created before webhooks were signed
+	db.Exec("UPDATE rules SET signing_secret = md5(random()::text || id::text) WHERE endpoint_url <> '' AND (signing_secret IS NULL OR signing_secret = '')")
+
+	// rules created before rule types existed
+	db.Exec("UPDATE rules SET type = ? WHERE type IS NULL OR type = ''", models.RuleTypeThreshold)
+
+	// rules created before rules could target groups of devices
+	db.Exec("UPDATE rules SET target = ? WHERE target IS NULL OR target = ''", models.RuleTargetDevice)
+
+	// roles of memberships created before roles existed
+	db.Exec("UPDATE user_tenants SET role = ? FROM users WHERE users.id = user_tenants.user_id AND users.is_admin = true AND user_tenants.role IS NULL", models.RoleOwner)
+	db.Exec("UPDATE user_tenants SET role = ? WHERE role IS NULL", models.RoleEditor)
+
 	// Create super admin user if not exists
 	superAdmin := models.User{}
 
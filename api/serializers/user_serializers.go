@@ -7,17 +7,28 @@ import (
 )
 
 type LoginSerializer struct {
-	Token string             `json:"token"`
-	User  ShowUserSerializer `json:"user"`
+	Token        string             `json:"token"`
+	RefreshToken string             `json:"refresh_token"`
+	ExpiresIn    int64              `json:"expires_in"`
+	User         ShowUserSerializer `json:"user"`
+}
+
+// MFAChallengeSerializer is returned by the login of users with two-factor
+// authentication, the mfa token is exchanged with a code in /api/login/mfa
+type MFAChallengeSerializer struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
 }
 
 type ShowUserSerializer struct {
-	ID        uuid.UUID   `json:"id"`
-	FirstName string      `json:"first_name"`
-	LastName  string      `json:"last_name"`
-	Email     string      `json:"email"`
-	Status    string      `json:"status"`
-	CreatedAt time.Time   `json:"created_at"`
-	UpdatedAt time.Time   `json:"updated_at"`
-	Tenants   interface{} `json:"tenants"`
+	ID         uuid.UUID   `json:"id"`
+	FirstName  string      `json:"first_name"`
+	LastName   string      `json:"last_name"`
+	Email      string      `json:"email"`
+	Status     string      `json:"status"`
+	MFAEnabled bool        `json:"mfa_enabled"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+	Tenants    interface{} `json:"tenants"`
 }
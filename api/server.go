@@ -3,6 +3,8 @@ package api
 import (
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"siot/api/controllers"
 	"siot/api/seed"
@@ -31,6 +33,29 @@ func Run() {
 
 	seed.Load(server.DB)
 
+	// webhook deliveries are sent in the background
+	server.Deliveries.Start()
+
+	// rules that do not depend on incoming data, like no data rules
+	server.Scheduler.Start()
+
+	// mqtt listener is optional
+	if os.Getenv("MQTT_PORT") != "" {
+		go server.RunMQTT(":" + os.Getenv("MQTT_PORT"))
+	}
+
+	// drain the requests and then the background workers on shutdown
+	done := make(chan struct{})
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+
+		server.Shutdown()
+		close(done)
+	}()
+
 	server.Run(":8080")
+	<-done
 
 }
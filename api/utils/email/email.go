@@ -43,3 +43,79 @@ func SendConfirmationEmail(to []string, subject string, firstName string, lastNa
 	// Sending email.
 	smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, body.Bytes())
 }
+
+func SendPasswordResetEmail(to []string, subject string, firstName string, lastName string, resetToken string, expiresIn string) {
+
+	// Sender data
+	from := os.Getenv("EMAIL")
+	password := os.Getenv("PASSWORD")
+
+	// smtp server configuration
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpPort := os.Getenv("SMTP_PORT")
+
+	// Authentication
+	auth := smtp.PlainAuth("", from, password, smtpHost)
+
+	t, _ := template.ParseFiles("api/utils/email/templates/password_reset_email_template.html")
+
+	var body bytes.Buffer
+
+	mimeHeaders := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
+	body.Write([]byte(fmt.Sprintf("Subject: "+subject+" \n%s\n\n", mimeHeaders)))
+
+	// the token is in the fragment so it is not sent to the servers and their logs,
+	// the page posts it in the body of the reset
+	resetUrl := os.Getenv("SERVER_URL") + "/api/password/reset#reset_token=" + resetToken
+
+	t.Execute(&body, struct {
+		FirstName string
+		LastName  string
+		ResetUrl  string
+		ExpiresIn string
+	}{
+		FirstName: firstName,
+		LastName:  lastName,
+		ResetUrl:  resetUrl,
+		ExpiresIn: expiresIn,
+	})
+
+	// Sending email.
+	smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, body.Bytes())
+}
+
+func SendAccountLockedEmail(to []string, subject string, firstName string, lastName string, lockedFor string, attempts string) {
+
+	// Sender data
+	from := os.Getenv("EMAIL")
+	password := os.Getenv("PASSWORD")
+
+	// smtp server configuration
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpPort := os.Getenv("SMTP_PORT")
+
+	// Authentication
+	auth := smtp.PlainAuth("", from, password, smtpHost)
+
+	t, _ := template.ParseFiles("api/utils/email/templates/account_locked_email_template.html")
+
+	var body bytes.Buffer
+
+	mimeHeaders := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
+	body.Write([]byte(fmt.Sprintf("Subject: "+subject+" \n%s\n\n", mimeHeaders)))
+
+	t.Execute(&body, struct {
+		FirstName string
+		LastName  string
+		LockedFor string
+		Attempts  string
+	}{
+		FirstName: firstName,
+		LastName:  lastName,
+		LockedFor: lockedFor,
+		Attempts:  attempts,
+	})
+
+	// Sending email.
+	smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, body.Bytes())
+}